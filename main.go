@@ -15,6 +15,36 @@ import (
 
 var wg = &sync.WaitGroup{}
 
+// timeoutsFromEnv returns driver timeouts with values overridden by environment variables,
+// cluster options take precedence over them
+func timeoutsFromEnv() (opentelekomcloud.Timeouts, error) {
+	timeouts := opentelekomcloud.DefaultTimeouts()
+	for name, value := range map[string]*int{
+		"OTC_CCE_NETWORK_TIMEOUT":    &timeouts.Network,
+		"OTC_CCE_CLUSTER_TIMEOUT":    &timeouts.Cluster,
+		"OTC_CCE_NODES_TIMEOUT":      &timeouts.Nodes,
+		"OTC_CCE_POST_CHECK_TIMEOUT": &timeouts.PostCheck,
+		"OTC_CCE_POLL_INTERVAL":      &timeouts.PollInterval,
+		"OTC_CCE_RETRIES":            &timeouts.Retries,
+		"OTC_CCE_RETRY_INTERVAL":     &timeouts.RetryInterval,
+		"OTC_CCE_MAX_RETRY_INTERVAL": &timeouts.MaxRetryInterval,
+	} {
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		// retries can be disabled, all other values are durations
+		if value == &timeouts.Retries && err == nil && parsed == 0 {
+			parsed = opentelekomcloud.NoRetries
+		} else if err != nil || parsed <= 0 {
+			return timeouts, fmt.Errorf("%s should be positive integer, got %q", name, raw)
+		}
+		*value = parsed
+	}
+	return timeouts, nil
+}
+
 func main() {
 	if os.Args[1] == "" {
 		panic(errors.New("no port provided"))
@@ -25,8 +55,13 @@ func main() {
 		panic(fmt.Errorf("argument not parsable as int: %v", err))
 	}
 
+	timeouts, err := timeoutsFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
 	addr := make(chan string)
-	go types.NewServer(opentelekomcloud.NewDriver(), addr).ServeOrDie(fmt.Sprintf("127.0.0.1:%v", port))
+	go types.NewServer(opentelekomcloud.NewDriverWithTimeouts(timeouts), addr).ServeOrDie(fmt.Sprintf("127.0.0.1:%v", port))
 
 	logrus.Infof("OpenTelekomCloud CCE driver up and running on at %v", <-addr)
 
@@ -0,0 +1,411 @@
+package opentelekomcloud
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/obs"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	snapshotSuffix        = ".tar.gz"
+	clusterScopeDir       = "_cluster"
+	coreGroupDir          = "core"
+	defaultOBSEndpointFmt = "https://obs.%s.otc.t-systems.com"
+)
+
+var (
+	// resources which are managed by the cluster itself and must not be saved
+	skippedResources = sets.New[string](
+		"events", "events.events.k8s.io", "nodes", "endpoints", "endpointslices.discovery.k8s.io",
+		"leases.coordination.k8s.io",
+	)
+	skippedNamespaces = sets.New[string]("kube-system", "kube-public", "kube-node-lease")
+)
+
+// backupOpts contains settings of resource snapshots stored in OBS
+type backupOpts struct {
+	Bucket    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	// RetentionCount is number of latest snapshots kept after save, 0 keeps all
+	RetentionCount int
+	// RetentionDays is max age of snapshots kept after save, 0 keeps all
+	RetentionDays int
+}
+
+// getBackupOpts returns backup settings from cluster state, overridden by non-empty `opts` values
+func getBackupOpts(state *clusterState, opts *types.DriverOptions) (*backupOpts, error) {
+	backup := state.Backup
+	if opts != nil {
+		strOpt, _, intOpt, _ := getters(opts)
+		if v := strOpt("backup-bucket", "backupBucket"); v != "" {
+			backup.Bucket = v
+		}
+		if v := strOpt("backup-obs-endpoint", "backupObsEndpoint"); v != "" {
+			backup.Endpoint = v
+		}
+		if v := strOpt("backup-access-key", "backupAccessKey"); v != "" {
+			backup.AccessKey = v
+		}
+		if v := strOpt("backup-secret-key", "backupSecretKey"); v != "" {
+			backup.SecretKey = v
+		}
+		if v := intOpt("backup-retention-count", "backupRetentionCount"); v != 0 {
+			backup.RetentionCount = int(v)
+		}
+		if v := intOpt("backup-retention-days", "backupRetentionDays"); v != 0 {
+			backup.RetentionDays = int(v)
+		}
+	}
+	if backup.AccessKey == "" && backup.SecretKey == "" {
+		backup.AccessKey = state.AuthInfo.AccessKey
+		backup.SecretKey = state.AuthInfo.SecretKey
+	}
+	if backup.Endpoint == "" {
+		backup.Endpoint = fmt.Sprintf(defaultOBSEndpointFmt, state.Region)
+	}
+	if backup.Bucket == "" {
+		return nil, fmt.Errorf("backup-bucket is required for snapshot operations")
+	}
+	if backup.AccessKey == "" || backup.SecretKey == "" {
+		return nil, fmt.Errorf("AK/SK are required for snapshot operations, set backup-access-key and backup-secret-key")
+	}
+	if backup.RetentionCount < 0 || backup.RetentionDays < 0 {
+		return nil, fmt.Errorf("backup retention values can't be negative")
+	}
+	return &backup, nil
+}
+
+func newOBSClient(backup *backupOpts) (*obs.ObsClient, error) {
+	return obs.New(backup.AccessKey, backup.SecretKey, backup.Endpoint)
+}
+
+func snapshotKey(clusterID, snapshotName string) string {
+	return path.Join(clusterID, snapshotName+snapshotSuffix)
+}
+
+// snapshotEntry identifies single object in the snapshot archive
+type snapshotEntry struct {
+	Group     string
+	Version   string
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+// path returns archive path of the entry: `<group>/<version>/<resource>/<namespace>/<name>.json`
+func (e snapshotEntry) path() string {
+	group := e.Group
+	if group == "" {
+		group = coreGroupDir
+	}
+	namespace := e.Namespace
+	if namespace == "" {
+		namespace = clusterScopeDir
+	}
+	return path.Join(group, e.Version, e.Resource, namespace, e.Name+".json")
+}
+
+func parseSnapshotPath(p string) (snapshotEntry, error) {
+	parts := strings.Split(p, "/")
+	if len(parts) != 5 || !strings.HasSuffix(parts[4], ".json") {
+		return snapshotEntry{}, fmt.Errorf("invalid snapshot entry: %s", p)
+	}
+	entry := snapshotEntry{
+		Group:     parts[0],
+		Version:   parts[1],
+		Resource:  parts[2],
+		Namespace: parts[3],
+		Name:      strings.TrimSuffix(parts[4], ".json"),
+	}
+	if entry.Group == coreGroupDir {
+		entry.Group = ""
+	}
+	if entry.Namespace == clusterScopeDir {
+		entry.Namespace = ""
+	}
+	return entry, nil
+}
+
+// resourcePath returns API path for listing or creating objects of the entry resource
+func (e snapshotEntry) resourcePath(namespaced bool) string {
+	base := path.Join("/apis", e.Group, e.Version)
+	if e.Group == "" {
+		base = path.Join("/api", e.Version)
+	}
+	if namespaced && e.Namespace != "" {
+		return path.Join(base, "namespaces", e.Namespace, e.Resource)
+	}
+	return path.Join(base, e.Resource)
+}
+
+func isSavable(resource metav1.APIResource, group string) bool {
+	fullName := resource.Name
+	if group != "" {
+		fullName += "." + group
+	}
+	if strings.Contains(resource.Name, "/") || skippedResources.Has(fullName) {
+		return false
+	}
+	verbs := sets.New[string](resource.Verbs...)
+	return verbs.HasAll("list", "create")
+}
+
+// sanitizeObject removes fields set by the cluster and returns false if the object should not be saved
+func sanitizeObject(obj *unstructured.Unstructured) bool {
+	if skippedNamespaces.Has(obj.GetNamespace()) || (obj.GetKind() == "Namespace" && skippedNamespaces.Has(obj.GetName())) {
+		return false
+	}
+	// objects owned by controllers are recreated by their owners
+	if metav1.GetControllerOf(obj) != nil {
+		return false
+	}
+	if obj.GetKind() == "Secret" {
+		if secretType, _, _ := unstructured.NestedString(obj.Object, "type"); secretType == string(v1.SecretTypeServiceAccountToken) {
+			return false
+		}
+	}
+	for _, field := range []string{"uid", "resourceVersion", "creationTimestamp", "managedFields", "selfLink", "generation"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	if obj.GetKind() == "Service" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+	}
+	return true
+}
+
+// exportResources returns JSON of all savable namespaced and cluster-scoped objects by archive path
+func exportResources(ctx context.Context, clientSet kubernetes.Interface) (map[string][]byte, error) {
+	resourceLists, err := discovery.ServerPreferredResources(clientSet.Discovery())
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover cluster resources: %s", err)
+		}
+		logrus.WithError(err).Warn("some API groups are not available, they won't be saved")
+	}
+	restClient := clientSet.Discovery().RESTClient()
+	result := map[string][]byte{}
+	for _, list := range resourceLists {
+		gv, err := parseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range list.APIResources {
+			if !isSavable(resource, gv.Group) {
+				continue
+			}
+			entry := snapshotEntry{Group: gv.Group, Version: gv.Version, Resource: resource.Name}
+			raw, err := restClient.Get().AbsPath(entry.resourcePath(false)).Do(ctx).Raw()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %s", list.GroupVersion+"/"+resource.Name, err)
+			}
+			objects := &unstructured.UnstructuredList{}
+			if err := objects.UnmarshalJSON(raw); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %s", list.GroupVersion+"/"+resource.Name, err)
+			}
+			for i := range objects.Items {
+				obj := &objects.Items[i]
+				if !sanitizeObject(obj) {
+					continue
+				}
+				data, err := json.Marshal(obj.Object)
+				if err != nil {
+					return nil, err
+				}
+				entry.Namespace = obj.GetNamespace()
+				entry.Name = obj.GetName()
+				result[entry.path()] = data
+			}
+		}
+	}
+	return result, nil
+}
+
+func parseGroupVersion(groupVersion string) (metav1.GroupVersion, error) {
+	parts := strings.Split(groupVersion, "/")
+	switch len(parts) {
+	case 1:
+		return metav1.GroupVersion{Version: parts[0]}, nil
+	case 2:
+		return metav1.GroupVersion{Group: parts[0], Version: parts[1]}, nil
+	}
+	return metav1.GroupVersion{}, fmt.Errorf("invalid group version: %s", groupVersion)
+}
+
+// writeSnapshot writes objects into gzipped tar archive
+func writeSnapshot(w io.Writer, objects map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for name, data := range objects {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// uploadSnapshot saves snapshot archive to the OBS bucket
+func uploadSnapshot(backup *backupOpts, key string, data []byte) error {
+	client, err := newOBSClient(backup)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	input := &obs.PutObjectInput{Body: bytes.NewReader(data)}
+	input.Bucket = backup.Bucket
+	input.Key = key
+	input.ContentType = "application/gzip"
+	input.ContentLength = int64(len(data))
+	_, err = client.PutObject(input)
+	return err
+}
+
+// saveSnapshot exports cluster resources and uploads them to OBS as `snapshotName`
+func saveSnapshot(ctx context.Context, clientSet kubernetes.Interface, backup *backupOpts, clusterID, snapshotName string) error {
+	objects, err := exportResources(ctx, clientSet)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := writeSnapshot(buf, objects); err != nil {
+		return fmt.Errorf("failed to write snapshot archive: %s", err)
+	}
+	key := snapshotKey(clusterID, snapshotName)
+	if err := uploadSnapshot(backup, key, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to upload snapshot to OBS: %s", err)
+	}
+	logrus.Infof("Snapshot with %d objects saved to %s/%s", len(objects), backup.Bucket, key)
+	// snapshot is already saved, so failed cleanup is not a reason to fail the save
+	if err := applyRetention(backup, clusterID, key, time.Now()); err != nil {
+		logrus.WithError(err).Warn("failed to apply snapshot retention policy")
+	}
+	return nil
+}
+
+func isOBSNotFound(err error) bool {
+	obsErr, ok := err.(obs.ObsError)
+	return ok && obsErr.StatusCode == http.StatusNotFound
+}
+
+// deleteSnapshot removes snapshot archive from the OBS bucket, missing snapshot is not an error
+func deleteSnapshot(backup *backupOpts, key string) error {
+	client, err := newOBSClient(backup)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.DeleteObject(&obs.DeleteObjectInput{Bucket: backup.Bucket, Key: key})
+	if err != nil && !isOBSNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// listSnapshots returns all snapshot archives of the cluster, newest first
+func listSnapshots(backup *backupOpts, clusterID string) ([]obs.Content, error) {
+	client, err := newOBSClient(backup)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	input := &obs.ListObjectsInput{Bucket: backup.Bucket}
+	input.Prefix = clusterID + "/"
+	var snapshots []obs.Content
+	for {
+		output, err := client.ListObjects(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range output.Contents {
+			if strings.HasSuffix(content.Key, snapshotSuffix) {
+				snapshots = append(snapshots, content)
+			}
+		}
+		if !output.IsTruncated {
+			break
+		}
+		input.Marker = output.NextMarker
+		if input.Marker == "" && len(output.Contents) > 0 {
+			input.Marker = output.Contents[len(output.Contents)-1].Key
+		}
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].LastModified.After(snapshots[j].LastModified)
+	})
+	return snapshots, nil
+}
+
+// expiredSnapshots returns keys of snapshots not matching the retention policy. `keep` is never expired
+func expiredSnapshots(backup *backupOpts, snapshots []obs.Content, keep string, now time.Time) []string {
+	var expired []string
+	kept := 0
+	for _, snapshot := range snapshots {
+		if snapshot.Key == keep {
+			kept++
+			continue
+		}
+		tooOld := backup.RetentionDays > 0 && now.Sub(snapshot.LastModified) > time.Duration(backup.RetentionDays)*24*time.Hour
+		tooMany := backup.RetentionCount > 0 && kept >= backup.RetentionCount
+		if tooOld || tooMany {
+			expired = append(expired, snapshot.Key)
+			continue
+		}
+		kept++
+	}
+	return expired
+}
+
+// applyRetention removes cluster snapshots exceeding retention count or age
+func applyRetention(backup *backupOpts, clusterID, keep string, now time.Time) error {
+	if backup.RetentionCount == 0 && backup.RetentionDays == 0 {
+		return nil
+	}
+	snapshots, err := listSnapshots(backup, clusterID)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %s", err)
+	}
+	var errs []error
+	for _, key := range expiredSnapshots(backup, snapshots, keep, now) {
+		logrus.Infof("Removing expired snapshot %s/%s", backup.Bucket, key)
+		if err := deleteSnapshot(backup, key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove snapshot %s: %s", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,259 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucket = "snapshots"
+
+// fakeOBS is an S3-compatible stand-in for the OBS bucket
+type fakeOBS struct {
+	sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+type fakeListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string   `xml:"Name"`
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int       `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func newFakeOBS(t *testing.T) (*fakeOBS, *httptest.Server) {
+	storage := &fakeOBS{objects: map[string][]byte{}, modified: map[string]time.Time{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storage.Lock()
+		defer storage.Unlock()
+		bucketPrefix := "/" + testBucket + "/"
+		if !strings.HasPrefix(r.URL.Path+"/", bucketPrefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, bucketPrefix)
+		switch {
+		case r.Method == http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			storage.objects[key] = data
+			storage.modified[key] = time.Now()
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == http.MethodDelete:
+			delete(storage.objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && (key == "" || r.URL.Path == "/"+testBucket):
+			result := fakeListResult{Name: testBucket}
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for k := range storage.objects {
+				if strings.HasPrefix(k, prefix) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				result.Contents = append(result.Contents, struct {
+					Key          string    `xml:"Key"`
+					LastModified time.Time `xml:"LastModified"`
+					Size         int       `xml:"Size"`
+				}{k, storage.modified[k], len(storage.objects[k])})
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			require.NoError(t, xml.NewEncoder(w).Encode(result))
+		case r.Method == http.MethodGet:
+			data, ok := storage.objects[key]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	return storage, server
+}
+
+func (f *fakeOBS) keys() []string {
+	f.Lock()
+	defer f.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newFakeKubeAPI returns minimal Kubernetes API server serving given lists by resource path
+func newFakeKubeAPI(t *testing.T, lists map[string]string) *httptest.Server {
+	writeJSON := func(w http.ResponseWriter, data string) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, data)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			writeJSON(w, `{"kind": "APIVersions", "versions": ["v1"]}`)
+		case "/apis":
+			writeJSON(w, `{"kind": "APIGroupList", "groups": []}`)
+		case "/api/v1":
+			writeJSON(w, `{"kind": "APIResourceList", "groupVersion": "v1", "resources": [
+				{"name": "namespaces", "namespaced": false, "kind": "Namespace", "verbs": ["create", "get", "list"]},
+				{"name": "configmaps", "namespaced": true, "kind": "ConfigMap", "verbs": ["create", "get", "list"]},
+				{"name": "events", "namespaced": true, "kind": "Event", "verbs": ["create", "get", "list"]},
+				{"name": "pods/log", "namespaced": true, "kind": "Pod", "verbs": ["get"]}
+			]}`)
+		default:
+			data, ok := lists[r.URL.Path]
+			if !ok {
+				t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(w, data)
+		}
+	}))
+}
+
+func TestDriver_ETCDSave(t *testing.T) {
+	storage, obsServer := newFakeOBS(t)
+	defer obsServer.Close()
+
+	kubeServer := newFakeKubeAPI(t, map[string]string{
+		"/api/v1/namespaces": `{"kind": "NamespaceList", "apiVersion": "v1", "items": [
+			{"metadata": {"name": "apps", "uid": "1", "resourceVersion": "10"}, "status": {"phase": "Active"}},
+			{"metadata": {"name": "kube-system"}}
+		]}`,
+		"/api/v1/configmaps": `{"kind": "ConfigMapList", "apiVersion": "v1", "items": [
+			{"metadata": {"name": "settings", "namespace": "apps"}, "data": {"key": "value"}},
+			{"metadata": {"name": "owned", "namespace": "apps", "ownerReferences": [
+				{"apiVersion": "v1", "kind": "Pod", "name": "p", "uid": "2", "controller": true}
+			]}}
+		]}`,
+	})
+	defer kubeServer.Close()
+
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		Backup: backupOpts{
+			Bucket:    testBucket,
+			Endpoint:  obsServer.URL,
+			AccessKey: "ak",
+			SecretKey: "sk",
+		},
+	}
+	info, err := stateToInfo(state, &types.ClusterInfo{Endpoint: kubeServer.URL})
+	require.NoError(t, err)
+
+	driver := NewDriver()
+	require.NoError(t, driver.ETCDSave(context.Background(), info, &types.DriverOptions{}, "snapshot-1"))
+	assert.Equal(t, []string{"cluster-id/snapshot-1.tar.gz"}, storage.keys())
+
+	objects, err := readSnapshot(bytes.NewReader(storage.objects["cluster-id/snapshot-1.tar.gz"]))
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	namespace := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(objects["core/v1/namespaces/_cluster/apps.json"], &namespace))
+	assert.Equal(t, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "apps"},
+	}, namespace)
+	assert.Contains(t, objects, "core/v1/configmaps/apps/settings.json")
+
+	retention := &types.DriverOptions{IntOptions: map[string]int64{"backup-retention-count": 1}}
+	require.NoError(t, driver.ETCDSave(context.Background(), info, retention, "snapshot-2"))
+	assert.Equal(t, []string{"cluster-id/snapshot-2.tar.gz"}, storage.keys(), "retention is applied after save")
+}
+
+func TestSnapshotEntryPath(t *testing.T) {
+	entries := []snapshotEntry{
+		{Version: "v1", Resource: "namespaces", Name: "apps"},
+		{Group: "apps", Version: "v1", Resource: "deployments", Namespace: "apps", Name: "web"},
+	}
+	for _, entry := range entries {
+		parsed, err := parseSnapshotPath(entry.path())
+		require.NoError(t, err)
+		assert.Equal(t, entry, parsed)
+	}
+	assert.Equal(t, "/api/v1/namespaces", entries[0].resourcePath(true))
+	assert.Equal(t, "/apis/apps/v1/namespaces/apps/deployments", entries[1].resourcePath(true))
+	assert.Equal(t, "/apis/apps/v1/deployments", entries[1].resourcePath(false))
+
+	_, err := parseSnapshotPath("core/v1/namespaces/apps.json")
+	assert.Error(t, err)
+}
+
+func TestDriver_ETCDRemoveSnapshot(t *testing.T) {
+	storage, obsServer := newFakeOBS(t)
+	defer obsServer.Close()
+	storage.objects["cluster-id/snapshot-1.tar.gz"] = []byte("data")
+	storage.objects["cluster-id/snapshot-2.tar.gz"] = []byte("data")
+
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		Backup:    backupOpts{Bucket: testBucket, Endpoint: obsServer.URL, AccessKey: "ak", SecretKey: "sk"},
+	}
+	info, err := stateToInfo(state, &types.ClusterInfo{})
+	require.NoError(t, err)
+
+	driver := NewDriver()
+	require.NoError(t, driver.ETCDRemoveSnapshot(context.Background(), info, &types.DriverOptions{}, "snapshot-1"))
+	assert.Equal(t, []string{"cluster-id/snapshot-2.tar.gz"}, storage.keys())
+	// removing already removed snapshot succeeds, so Rancher can drop it
+	require.NoError(t, driver.ETCDRemoveSnapshot(context.Background(), info, &types.DriverOptions{}, "snapshot-1"))
+}
+
+func TestApplyRetention(t *testing.T) {
+	storage, obsServer := newFakeOBS(t)
+	defer obsServer.Close()
+	now := time.Now()
+	for i, age := range []time.Duration{0, time.Hour, 25 * time.Hour, 49 * time.Hour, 73 * time.Hour} {
+		key := fmt.Sprintf("cluster-id/snapshot-%d.tar.gz", i)
+		storage.objects[key] = []byte("data")
+		storage.modified[key] = now.Add(-age)
+	}
+	storage.objects["other-cluster/snapshot-9.tar.gz"] = []byte("data")
+	storage.modified["other-cluster/snapshot-9.tar.gz"] = now.Add(-100 * time.Hour)
+
+	backup := &backupOpts{Bucket: testBucket, Endpoint: obsServer.URL, AccessKey: "ak", SecretKey: "sk"}
+	require.NoError(t, applyRetention(backup, "cluster-id", "cluster-id/snapshot-0.tar.gz", now))
+	assert.Len(t, storage.keys(), 6, "nothing is removed without retention settings")
+
+	backup.RetentionDays = 3
+	require.NoError(t, applyRetention(backup, "cluster-id", "cluster-id/snapshot-0.tar.gz", now))
+	assert.NotContains(t, storage.keys(), "cluster-id/snapshot-4.tar.gz")
+	assert.Contains(t, storage.keys(), "other-cluster/snapshot-9.tar.gz")
+
+	backup.RetentionCount = 2
+	require.NoError(t, applyRetention(backup, "cluster-id", "cluster-id/snapshot-0.tar.gz", now))
+	assert.Equal(t, []string{
+		"cluster-id/snapshot-0.tar.gz",
+		"cluster-id/snapshot-1.tar.gz",
+		"other-cluster/snapshot-9.tar.gz",
+	}, storage.keys())
+}
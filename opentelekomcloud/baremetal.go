@@ -0,0 +1,82 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+)
+
+const bmsFlavorPrefix = "physical."
+
+func isBMSFlavor(flavor string) bool {
+	return strings.HasPrefix(flavor, bmsFlavorPrefix)
+}
+
+// validateBareMetal checks that BareMetal clusters have highway subnet and use BMS flavors only
+func validateBareMetal(state *clusterState) error {
+	if state.ClusterType != services.ClusterTypeBMS {
+		for _, pool := range state.NodePools {
+			if isBMSFlavor(pool.FlavorID) {
+				return fmt.Errorf("node flavor %s of pool %s requires %s cluster-type",
+					pool.FlavorID, pool.Name, services.ClusterTypeBMS)
+			}
+		}
+		return nil
+	}
+	if state.HighwaySubnetID == "" && state.HighwaySubnetName == "" {
+		return fmt.Errorf("highway-subnet or highway-subnet-id is required for %s clusters", services.ClusterTypeBMS)
+	}
+	for _, pool := range state.NodePools {
+		if !isBMSFlavor(pool.FlavorID) {
+			return fmt.Errorf("node flavor %s of pool %s is not BMS flavor, %s clusters require %s* flavors",
+				pool.FlavorID, pool.Name, services.ClusterTypeBMS, bmsFlavorPrefix)
+		}
+	}
+	return nil
+}
+
+// createBMSNodes adds `count` BMS nodes to the pool and waits until they are active
+func createBMSNodes(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool, count int) error {
+	spec := nodeTemplate(state, pool)
+	spec.Count = count
+	created, err := nodes.Create(client.CCE, state.ClusterID, nodeCreateOpts{
+		Kind:       "Node",
+		ApiVersion: "v3",
+		Metadata:   nodes.CreateMetaData{Name: pool.Name},
+		Spec:       spec,
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("failed to create BMS nodes of pool %s: %s", pool.Name, err)
+	}
+	nodeIDs := strings.Split(created.Metadata.Id, ",")
+	// IDs are saved before waiting, so failed nodes can be removed
+	pool.NodeIDs = append(pool.NodeIDs, nodeIDs...)
+	addNodeJob(state, created.Status.JobID)
+	logrus.Infof("Waiting for BMS nodes %s of pool %s to become available", created.Metadata.Id, pool.Name)
+	if err := waitForNodesActive(ctx, client, state, nodeIDs); err != nil {
+		return err
+	}
+	pool.Count = len(pool.NodeIDs)
+	return nil
+}
+
+// scaleBMSNodes creates or deletes BMS nodes of the pool, latest nodes are deleted first
+func scaleBMSNodes(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool, count int) error {
+	current := len(pool.NodeIDs)
+	if count > current {
+		return createBMSNodes(ctx, client, state, pool, count-current)
+	}
+	if count == current {
+		return nil
+	}
+	if err := deleteNodes(ctx, client, state, pool.NodeIDs[count:]); err != nil {
+		return fmt.Errorf("failed to delete BMS nodes of pool %s: %s", pool.Name, err)
+	}
+	pool.NodeIDs = pool.NodeIDs[:count]
+	pool.Count = count
+	return nil
+}
@@ -0,0 +1,66 @@
+package opentelekomcloud
+
+import (
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBareMetal(t *testing.T) {
+	bmsPools := []nodePool{{Name: "pool-1", FlavorID: "physical.o2.medium"}}
+	vmPools := []nodePool{{Name: "pool-1", FlavorID: "s3.large.2"}}
+
+	assert.NoError(t, validateBareMetal(&clusterState{ClusterType: services.ClusterTypeECS, NodePools: vmPools}))
+	assert.Error(t, validateBareMetal(&clusterState{ClusterType: services.ClusterTypeECS, NodePools: bmsPools}))
+
+	assert.NoError(t, validateBareMetal(&clusterState{
+		ClusterType: services.ClusterTypeBMS, HighwaySubnetName: "highway", NodePools: bmsPools,
+	}))
+	assert.Error(t, validateBareMetal(&clusterState{
+		ClusterType: services.ClusterTypeBMS, NodePools: bmsPools,
+	}), "highway subnet is required")
+	assert.Error(t, validateBareMetal(&clusterState{
+		ClusterType: services.ClusterTypeBMS, HighwaySubnetID: "id", NodePools: vmPools,
+	}))
+}
+
+func TestValidateBareMetalClusterSpec(t *testing.T) {
+	opts := &types.DriverOptions{
+		StringOptions: map[string]string{
+			"name":                   "bms",
+			"cluster-type":           services.ClusterTypeBMS,
+			"cluster-version":        "v1.25",
+			"cluster-flavor":         "cce.t2.small",
+			"vpc":                    "vpc",
+			"subnet":                 "subnet",
+			"highway-subnet":         "highway",
+			"container-network-mode": services.ContainerNetworkModeUnderlay,
+			"node-flavor":            "physical.o2.medium",
+			"node-os":                "EulerOS 2.9",
+			"availability-zone":      "eu-de-01",
+			"key-pair":               "key",
+			"root-volume-type":       "SAS",
+			"data-volume-type":       "SAS",
+			"bms-period-type":        "month",
+		},
+		IntOptions: map[string]int64{
+			"node-count":       2,
+			"root-volume-size": 40,
+			"data-volume-size": 100,
+			"bms-period-num":   1,
+		},
+	}
+	state, err := optsToState(opts)
+	require.NoError(t, err)
+	assert.NoError(t, validateState(staticCatalog, state), "complete BareMetal spec is valid")
+
+	state.ClusterFlavor = "cce.s2.small"
+	assert.Error(t, validateState(staticCatalog, state), "BareMetal cluster requires BMS cluster flavor")
+
+	state.ClusterType = services.ClusterTypeECS
+	state.ClusterFlavor = "cce.t1.small"
+	assert.Error(t, validateState(staticCatalog, state), "BMS cluster flavor requires BareMetal cluster")
+}
@@ -0,0 +1,87 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	billingModeOnDemand = 0
+	billingModePrepaid  = 1
+
+	// chargingModePrepaid is yearly/monthly node charging
+	chargingModePrepaid = 1
+
+	periodTypeMonth = "month"
+	periodTypeYear  = "year"
+	maxPeriodMonths = 9
+	maxPeriodYears  = 3
+)
+
+// periodOpts contains subscription period of prepaid cluster and nodes
+type periodOpts struct {
+	PeriodType string
+	PeriodNum  int
+	AutoRenew  bool
+}
+
+func (s *clusterState) hasPrepaidResources() bool {
+	return s.ClusterBillingMode == billingModePrepaid || s.NodeConfig.ChargingMode == chargingModePrepaid
+}
+
+// validateBilling checks billing modes and subscription period used by prepaid resources
+func validateBilling(state *clusterState) error {
+	for name, mode := range map[string]int{
+		"cluster-billing-mode": state.ClusterBillingMode,
+		"billing-mode":         state.NodeConfig.BillingMode,
+	} {
+		if mode != billingModeOnDemand && mode != billingModePrepaid {
+			return fmt.Errorf("%s should be %d (on-demand) or %d (prepaid), got %d",
+				name, billingModeOnDemand, billingModePrepaid, mode)
+		}
+	}
+	if !state.hasPrepaidResources() {
+		return nil
+	}
+	period := state.Period
+	maxPeriod := 0
+	switch period.PeriodType {
+	case periodTypeMonth:
+		maxPeriod = maxPeriodMonths
+	case periodTypeYear:
+		maxPeriod = maxPeriodYears
+	default:
+		return fmt.Errorf("bms-period-type should be %s or %s for prepaid resources, got %s",
+			periodTypeMonth, periodTypeYear, period.PeriodType)
+	}
+	if period.PeriodNum < 1 || period.PeriodNum > maxPeriod {
+		return fmt.Errorf("bms-period-num should be between 1 and %d for %s period, got %d",
+			maxPeriod, period.PeriodType, period.PeriodNum)
+	}
+	return nil
+}
+
+// setClusterPeriod adds subscription period of prepaid cluster, orders are paid automatically
+func setClusterPeriod(extendParam map[string]string, period *periodOpts) {
+	extendParam["periodType"] = period.PeriodType
+	extendParam["periodNum"] = strconv.Itoa(period.PeriodNum)
+	extendParam["isAutoRenew"] = strconv.FormatBool(period.AutoRenew)
+	extendParam["isAutoPay"] = "true"
+}
+
+// setNodePeriod adds subscription period of prepaid nodes, orders are paid automatically
+func setNodePeriod(param *nodeExtendParam, period *periodOpts) {
+	autoPay := true
+	autoRenew := period.AutoRenew
+	param.ChargingMode = chargingModePrepaid
+	param.PeriodType = period.PeriodType
+	param.PeriodNum = period.PeriodNum
+	param.IsAutoPay = &autoPay
+	param.IsAutoRenew = &autoRenew
+}
+
+// prepaidDeleteError explains failed removal of prepaid resource, which has to be unsubscribed first
+func prepaidDeleteError(resource string, err error) error {
+	return fmt.Errorf("failed to delete prepaid %s: %s. Prepaid resources are removed after unsubscription "+
+		"in the Billing Center, unsubscribe them and remove the cluster again", resource, err)
+}
@@ -0,0 +1,37 @@
+package opentelekomcloud
+
+import (
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBilling(t *testing.T) {
+	assert.NoError(t, validateBilling(&clusterState{}), "on-demand resources need no period")
+	assert.Error(t, validateBilling(&clusterState{ClusterBillingMode: 2}))
+	assert.Error(t, validateBilling(&clusterState{NodeConfig: services.CreateNodesOpts{BillingMode: 3}}))
+
+	prepaid := func(period periodOpts) *clusterState {
+		return &clusterState{ClusterBillingMode: billingModePrepaid, Period: period}
+	}
+	assert.NoError(t, validateBilling(prepaid(periodOpts{PeriodType: "month", PeriodNum: 9})))
+	assert.NoError(t, validateBilling(prepaid(periodOpts{PeriodType: "year", PeriodNum: 3, AutoRenew: true})))
+	assert.Error(t, validateBilling(prepaid(periodOpts{PeriodType: "month", PeriodNum: 10})))
+	assert.Error(t, validateBilling(prepaid(periodOpts{PeriodType: "year", PeriodNum: 0})))
+	assert.Error(t, validateBilling(prepaid(periodOpts{PeriodType: "week", PeriodNum: 1})))
+	assert.Error(t, validateBilling(&clusterState{
+		NodeConfig: services.CreateNodesOpts{ChargingMode: chargingModePrepaid},
+	}), "prepaid nodes require period")
+}
+
+func TestSetClusterPeriod(t *testing.T) {
+	extendParam := map[string]string{}
+	setClusterPeriod(extendParam, &periodOpts{PeriodType: "year", PeriodNum: 1, AutoRenew: true})
+	assert.Equal(t, map[string]string{
+		"periodType":  "year",
+		"periodNum":   "1",
+		"isAutoRenew": "true",
+		"isAutoPay":   "true",
+	}, extendParam)
+}
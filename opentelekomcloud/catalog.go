@@ -0,0 +1,195 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// flavors with this prefix have 3 masters
+	haClusterFlavorPrefix    = "cce.s2."
+	haBMSClusterFlavorPrefix = "cce.t2."
+	haMasterCount            = 3
+
+	// flavors of BareMetal clusters have this prefix
+	bmsClusterFlavorPrefix = "cce.t"
+
+	catalogTTL = 1 * time.Hour
+)
+
+// clusterCatalog contains k8s versions and cluster flavors supported by CCE in the region
+type clusterCatalog struct {
+	Versions []string
+	Flavors  []string
+	fetched  time.Time
+}
+
+var (
+	// staticCatalog is used when the catalog can't be loaded from CCE API
+	staticCatalog = &clusterCatalog{
+		Versions: clusterVersions,
+		Flavors:  clusterFlavors,
+	}
+
+	catalogCache = map[string]*clusterCatalog{}
+	catalogLock  sync.Mutex
+)
+
+type upgradePath struct {
+	Version        string   `json:"version"`
+	TargetVersions []string `json:"targetVersions"`
+}
+
+type upgradePathList struct {
+	UpgradePaths []upgradePath `json:"upgradePaths"`
+}
+
+// fetchCatalog loads cluster versions from CCE upgrade paths, which list every version clusters can run or be
+// upgraded to. CCE API doesn't list cluster flavors, so flavors of the static catalog are used
+func fetchCatalog(client *services.Client) (*clusterCatalog, error) {
+	paths := &upgradePathList{}
+	if _, err := client.CCE.Get(client.CCE.Endpoint+"api/v3/clusterupgradepaths", paths, nil); err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	add := func(version string) {
+		major, minor, err := majorMinorVersion(version)
+		if err != nil {
+			return
+		}
+		found[fmt.Sprintf("v%d.%d", major, minor)] = true
+	}
+	for _, path := range paths.UpgradePaths {
+		add(path.Version)
+		for _, target := range path.TargetVersions {
+			add(target)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("empty list of cluster upgrade paths returned")
+	}
+	catalog := &clusterCatalog{Flavors: clusterFlavors, fetched: time.Now()}
+	for version := range found {
+		catalog.Versions = append(catalog.Versions, version)
+	}
+	sort.Slice(catalog.Versions, func(i, j int) bool {
+		cmp, _ := compareVersions(catalog.Versions[i], catalog.Versions[j])
+		return cmp < 0
+	})
+	return catalog, nil
+}
+
+// getCatalog returns cached catalog of the region, refreshing it when TTL is expired. The last loaded catalog
+// or the static one is returned if CCE API is not reachable
+func getCatalog(client *services.Client, region string) *clusterCatalog {
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+
+	cached, ok := catalogCache[region]
+	if ok && time.Since(cached.fetched) < catalogTTL {
+		return cached
+	}
+	catalog, err := fetchCatalog(client)
+	if err != nil {
+		if ok {
+			logrus.WithError(err).Warnf("Failed to refresh CCE catalog of %s, using cached one", region)
+			return cached
+		}
+		logrus.WithError(err).Warnf("Failed to load CCE catalog of %s, using static one", region)
+		return staticCatalog
+	}
+	catalogCache[region] = catalog
+	return catalog
+}
+
+// supportsVersion checks if major and minor version is in the catalog, both `v1.25` and `v1.25.3` match `v1.25`
+func (c *clusterCatalog) supportsVersion(version string) bool {
+	for _, v := range c.Versions {
+		if cmp, err := compareVersions(version, v); err == nil && cmp == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextVersion returns the lowest catalog version above the version or empty string if there is none
+func (c *clusterCatalog) nextVersion(version string) string {
+	next := ""
+	for _, v := range c.Versions {
+		if cmp, err := compareVersions(v, version); err != nil || cmp <= 0 {
+			continue
+		}
+		if cmp, _ := compareVersions(v, next); next == "" || cmp < 0 {
+			next = v
+		}
+	}
+	return next
+}
+
+func (c *clusterCatalog) supportsFlavor(flavor string) bool {
+	for _, f := range c.Flavors {
+		if f == flavor {
+			return true
+		}
+	}
+	return false
+}
+
+// isHAClusterFlavor returns true for cluster flavors with 3 masters
+func isHAClusterFlavor(flavor string) bool {
+	return strings.HasPrefix(flavor, haClusterFlavorPrefix) || strings.HasPrefix(flavor, haBMSClusterFlavorPrefix)
+}
+
+// validateClusterSpec checks that cluster version and flavor are supported and the flavor matches cluster type
+func validateClusterSpec(catalog *clusterCatalog, state *clusterState) error {
+	if state.ClusterVersion != "" && !catalog.supportsVersion(state.ClusterVersion) {
+		return fmt.Errorf("unsupported cluster-version %s, should be one of %s",
+			state.ClusterVersion, strings.Join(catalog.Versions, ", "))
+	}
+	if !catalog.supportsFlavor(state.ClusterFlavor) {
+		return fmt.Errorf("unsupported cluster-flavor %s, should be one of %s",
+			state.ClusterFlavor, strings.Join(catalog.Flavors, ", "))
+	}
+	if bmsFlavor := strings.HasPrefix(state.ClusterFlavor, bmsClusterFlavorPrefix); bmsFlavor != (state.ClusterType == services.ClusterTypeBMS) {
+		if bmsFlavor {
+			return fmt.Errorf("cluster-flavor %s can be used only by %s clusters", state.ClusterFlavor, services.ClusterTypeBMS)
+		}
+		return fmt.Errorf("%s clusters require %s* cluster-flavor, got %s",
+			services.ClusterTypeBMS, bmsClusterFlavorPrefix, state.ClusterFlavor)
+	}
+	return validateMasterPlacement(state)
+}
+
+// validateMasterPlacement checks that master AZs match the number of cluster masters:
+// single master flavors require single AZ, HA masters are placed either to one or to three different AZs
+func validateMasterPlacement(state *clusterState) error {
+	azs := state.MasterAZs
+	if len(azs) == 0 {
+		return nil
+	}
+	unique := map[string]bool{}
+	for _, az := range azs {
+		if az == "" || unique[az] {
+			return fmt.Errorf("master-availability-zones should contain different non-empty AZs")
+		}
+		unique[az] = true
+	}
+	if isHAClusterFlavor(state.ClusterFlavor) {
+		if len(azs) != 1 && len(azs) != haMasterCount {
+			return fmt.Errorf("cluster-flavor %s requires 1 or %d master-availability-zones, got %d",
+				state.ClusterFlavor, haMasterCount, len(azs))
+		}
+		return nil
+	}
+	if len(azs) != 1 {
+		return fmt.Errorf("cluster-flavor %s has single master and requires exactly 1 master-availability-zones value, got %d",
+			state.ClusterFlavor, len(azs))
+	}
+	return nil
+}
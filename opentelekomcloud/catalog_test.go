@@ -0,0 +1,101 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeCCEClient(url string) *services.Client {
+	return &services.Client{
+		CCE: &golangsdk.ServiceClient{
+			ProviderClient: &golangsdk.ProviderClient{HTTPClient: http.Client{}},
+			Endpoint:       url + "/",
+			ResourceBase:   url + "/api/v3/projects/test/",
+		},
+	}
+}
+
+func TestGetCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/clusterupgradepaths", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"upgradePaths": [
+			{"version": "v1.25", "targetVersions": ["v1.27"]},
+			{"version": "v1.23", "targetVersions": ["v1.25"]},
+			{"version": "v1.27.3", "targetVersions": ["v1.28"]}
+		]}`)
+	}))
+	defer server.Close()
+
+	catalog := getCatalog(fakeCCEClient(server.URL), "catalog-region")
+	assert.Equal(t, []string{"v1.23", "v1.25", "v1.27", "v1.28"}, catalog.Versions)
+	assert.Equal(t, clusterFlavors, catalog.Flavors)
+
+	server.Close()
+	assert.Equal(t, catalog, getCatalog(fakeCCEClient(server.URL), "catalog-region"), "cached catalog expected")
+	catalog.fetched = time.Now().Add(-2 * catalogTTL)
+	assert.Equal(t, catalog, getCatalog(fakeCCEClient(server.URL), "catalog-region"), "expired catalog is used offline")
+	assert.Equal(t, staticCatalog, getCatalog(fakeCCEClient(server.URL), "offline-region"))
+}
+
+func TestSupportsVersion(t *testing.T) {
+	catalog := &clusterCatalog{Versions: []string{"v1.25", "v1.27"}}
+	assert.True(t, catalog.supportsVersion("v1.25"))
+	assert.True(t, catalog.supportsVersion("1.27.3"))
+	assert.False(t, catalog.supportsVersion("v1.29"))
+	assert.False(t, catalog.supportsVersion("v9.0"))
+	assert.False(t, catalog.supportsVersion("v1"))
+	assert.False(t, catalog.supportsVersion("v1.2"))
+	assert.False(t, catalog.supportsVersion("v1.26"))
+	assert.False(t, catalog.supportsVersion("v1.23"))
+}
+
+func TestNextVersion(t *testing.T) {
+	catalog := &clusterCatalog{Versions: []string{"v1.28", "v1.23", "v1.25", "v1.27"}}
+	assert.Equal(t, "v1.25", catalog.nextVersion("v1.23.5-r0"))
+	assert.Equal(t, "v1.25", catalog.nextVersion("v1.24"))
+	assert.Equal(t, "", catalog.nextVersion("v1.28"))
+}
+
+func TestValidateClusterSpec(t *testing.T) {
+	catalog := &clusterCatalog{
+		Versions: []string{"v1.25", "v1.27"},
+		Flavors:  []string{"cce.s1.small", "cce.s2.small"},
+	}
+	assert.NoError(t, validateClusterSpec(catalog, &clusterState{ClusterFlavor: "cce.s1.small"}))
+	assert.NoError(t, validateClusterSpec(catalog, &clusterState{ClusterVersion: "v1.25", ClusterFlavor: "cce.s2.small"}))
+	assert.NoError(t, validateClusterSpec(catalog, &clusterState{ClusterVersion: "v1.27.3", ClusterFlavor: "cce.s2.small"}))
+	assert.Error(t, validateClusterSpec(catalog, &clusterState{ClusterVersion: "v1.2", ClusterFlavor: "cce.s2.small"}))
+	assert.Error(t, validateClusterSpec(catalog, &clusterState{ClusterVersion: "v1.23", ClusterFlavor: "cce.s2.small"}))
+	assert.Error(t, validateClusterSpec(catalog, &clusterState{ClusterVersion: "v1.25", ClusterFlavor: "cce.s3.small"}))
+}
+
+func TestValidateMasterPlacement(t *testing.T) {
+	assert.NoError(t, validateMasterPlacement(&clusterState{ClusterFlavor: "cce.s1.small"}))
+	assert.NoError(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s1.small", MasterAZs: []string{"eu-de-01"},
+	}))
+	assert.Error(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s1.small", MasterAZs: []string{"eu-de-01", "eu-de-02"},
+	}))
+	assert.NoError(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s2.small", MasterAZs: []string{"eu-de-01"},
+	}))
+	assert.NoError(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s2.small", MasterAZs: []string{"eu-de-01", "eu-de-02", "eu-de-03"},
+	}))
+	assert.Error(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s2.small", MasterAZs: []string{"eu-de-01", "eu-de-02"},
+	}))
+	assert.Error(t, validateMasterPlacement(&clusterState{
+		ClusterFlavor: "cce.s2.small", MasterAZs: []string{"eu-de-01", "eu-de-01", "eu-de-02"},
+	}))
+}
@@ -0,0 +1,423 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodepools"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+)
+
+// CCE API operations which are not (yet) covered by gophertelekomcloud
+
+const (
+	upgradeTaskSuccess = "Success"
+	upgradeTaskFailed  = "Failed"
+
+	upgradeStrategyInPlace = "inPlaceRollingUpdate"
+
+	multiAZ = "multi_az"
+
+	clusterPhaseDeleting = "Deleting"
+)
+
+// eniSubnet is the IPv4 subnet used for ENI containers
+type eniSubnet struct {
+	SubnetID string `json:"subnetID"`
+}
+
+type eniNetworkSpec struct {
+	EniSubnetID   string      `json:"eniSubnetId"`
+	EniSubnetCIDR string      `json:"eniSubnetCIDR,omitempty"`
+	Subnets       []eniSubnet `json:"subnets"`
+}
+
+// masterSpec describes placement of single cluster master
+type masterSpec struct {
+	AvailabilityZone string `json:"availabilityZone"`
+}
+
+// clusterSpec extends SDK cluster spec with fields the SDK doesn't support
+type clusterSpec struct {
+	clusters.Spec
+	Category   string          `json:"category,omitempty"`
+	EniNetwork *eniNetworkSpec `json:"eniNetwork,omitempty"`
+	Masters    []masterSpec    `json:"masters,omitempty"`
+}
+
+// clusterCreateOpts implements clusters.CreateOptsBuilder using extended cluster spec
+type clusterCreateOpts struct {
+	Kind       string                  `json:"kind"`
+	ApiVersion string                  `json:"apiVersion"`
+	Metadata   clusters.CreateMetaData `json:"metadata"`
+	Spec       clusterSpec             `json:"spec"`
+}
+
+func (opts clusterCreateOpts) ToClusterCreateMap() (map[string]interface{}, error) {
+	return requestBody(opts)
+}
+
+type clusterUpdateMetadata struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// clusterUpdateOpts implements clusters.UpdateOptsBuilder allowing to update cluster labels
+type clusterUpdateOpts struct {
+	Metadata clusterUpdateMetadata `json:"metadata"`
+	Spec     clusters.UpdateSpec   `json:"spec"`
+}
+
+func (opts clusterUpdateOpts) ToClusterUpdateMap() (map[string]interface{}, error) {
+	return requestBody(opts)
+}
+
+// nodeExtendParam extends SDK node extend params with fields the SDK doesn't support
+type nodeExtendParam struct {
+	nodes.ExtendParam
+	PeriodType string `json:"periodType,omitempty"`
+	PeriodNum  int    `json:"periodNum,omitempty"`
+}
+
+// nodeBandwidth extends SDK node EIP bandwidth with ID of shared bandwidth
+type nodeBandwidth struct {
+	nodes.BandwidthOpts
+	ID string `json:"id,omitempty"`
+}
+
+type nodeEip struct {
+	IpType    string        `json:"iptype"`
+	Bandwidth nodeBandwidth `json:"bandwidth"`
+}
+
+type nodePublicIP struct {
+	Count int     `json:"count"`
+	Eip   nodeEip `json:"eip"`
+}
+
+// nodeSpec is SDK node spec with extended `ExtendParam` and `PublicIP`, which shadow the ones of nodes.Spec
+type nodeSpec struct {
+	nodes.Spec
+	ExtendParam nodeExtendParam `json:"extendParam,omitempty"`
+	PublicIP    *nodePublicIP   `json:"publicIP,omitempty"`
+}
+
+type nodePoolSpec struct {
+	Type                 string   `json:"type"`
+	NodeTemplate         nodeSpec `json:"nodeTemplate"`
+	InitialNodeCount     int      `json:"initialNodeCount"`
+	CustomSecurityGroups []string `json:"customSecurityGroups,omitempty"`
+}
+
+// nodePoolCreateOpts implements nodepools.CreateOptsBuilder using extended node spec
+type nodePoolCreateOpts struct {
+	Kind       string                   `json:"kind"`
+	ApiVersion string                   `json:"apiVersion"`
+	Metadata   nodepools.CreateMetaData `json:"metadata"`
+	Spec       nodePoolSpec             `json:"spec"`
+}
+
+func (opts nodePoolCreateOpts) ToNodePoolCreateMap() (map[string]interface{}, error) {
+	return requestBody(opts)
+}
+
+// nodeTemplateUpdate is node pool template update, empty labels and taints are sent to remove existing ones
+type nodeTemplateUpdate struct {
+	K8sTags map[string]string `json:"k8sTags"`
+	Taints  []nodes.TaintSpec `json:"taints"`
+}
+
+type nodePoolUpdateSpec struct {
+	NodeTemplate     *nodeTemplateUpdate `json:"nodeTemplate,omitempty"`
+	InitialNodeCount int                 `json:"initialNodeCount"`
+}
+
+// nodePoolUpdateOpts implements nodepools.UpdateOptsBuilder, node template is sent only when it's set
+type nodePoolUpdateOpts struct {
+	Kind       string                   `json:"kind"`
+	ApiVersion string                   `json:"apiVersion"`
+	Metadata   nodepools.UpdateMetaData `json:"metadata"`
+	Spec       nodePoolUpdateSpec       `json:"spec"`
+}
+
+func (opts nodePoolUpdateOpts) ToNodePoolUpdateMap() (map[string]interface{}, error) {
+	return requestBody(opts)
+}
+
+// nodeCreateOpts implements nodes.CreateOptsBuilder using extended node spec
+type nodeCreateOpts struct {
+	Kind       string               `json:"kind"`
+	ApiVersion string               `json:"apiVersion"`
+	Metadata   nodes.CreateMetaData `json:"metadata"`
+	Spec       nodeSpec             `json:"spec"`
+}
+
+func (opts nodeCreateOpts) ToNodeCreateMap() (map[string]interface{}, error) {
+	return requestBody(opts)
+}
+
+func requestBody(opts interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{}
+	err = json.Unmarshal(data, &body)
+	return body, err
+}
+
+type upgradeMetadata struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+type inPlaceRollingUpdate struct {
+	UserDefinedStep int `json:"userDefinedStep,omitempty"`
+}
+
+type upgradeStrategy struct {
+	Type                 string                `json:"type"`
+	InPlaceRollingUpdate *inPlaceRollingUpdate `json:"inPlaceRollingUpdate,omitempty"`
+}
+
+type clusterUpgradeAction struct {
+	TargetVersion string          `json:"targetVersion"`
+	Strategy      upgradeStrategy `json:"strategy"`
+}
+
+type upgradeOpts struct {
+	Metadata upgradeMetadata `json:"metadata"`
+	Spec     struct {
+		ClusterUpgradeAction clusterUpgradeAction `json:"clusterUpgradeAction"`
+	} `json:"spec"`
+}
+
+type upgradeTask struct {
+	Metadata struct {
+		UID string `json:"uid"`
+	} `json:"metadata"`
+	Spec struct {
+		Version       string `json:"version"`
+		TargetVersion string `json:"targetVersion"`
+	} `json:"spec"`
+	Status struct {
+		Phase    string `json:"phase"`
+		Progress string `json:"progress"`
+	} `json:"status"`
+}
+
+// startClusterUpgrade starts in-place rolling upgrade of the cluster. Masters are upgraded first,
+// worker nodes are upgraded by the same task afterwards. Returns upgrade task ID.
+func startClusterUpgrade(client *services.Client, clusterID, version string) (string, error) {
+	opts := upgradeOpts{
+		Metadata: upgradeMetadata{APIVersion: "v3", Kind: "UpgradeTask"},
+	}
+	opts.Spec.ClusterUpgradeAction = clusterUpgradeAction{
+		TargetVersion: version,
+		Strategy: upgradeStrategy{
+			Type:                 upgradeStrategyInPlace,
+			InPlaceRollingUpdate: &inPlaceRollingUpdate{UserDefinedStep: 20},
+		},
+	}
+	task := &upgradeTask{}
+	url := client.CCE.ServiceURL("clusters", clusterID, "operation", "upgrade")
+	_, err := client.CCE.Post(url, opts, task, &golangsdk.RequestOpts{OkCodes: []int{200, 201}})
+	if err != nil {
+		return "", err
+	}
+	return task.Metadata.UID, nil
+}
+
+func getUpgradeTask(client *services.Client, clusterID, taskID string) (*upgradeTask, error) {
+	task := &upgradeTask{}
+	url := client.CCE.ServiceURL("clusters", clusterID, "operation", "upgrade", "tasks", taskID)
+	_, err := client.CCE.Get(url, task, nil)
+	return task, err
+}
+
+// waitForUpgradeTask waits until upgrade task is finished successfully. Upgrade of masters and nodes
+// is limited by the sum of cluster and nodes timeouts
+func waitForUpgradeTask(ctx context.Context, client *services.Client, state *clusterState, taskID string) error {
+	clusterID := state.ClusterID
+	return waitFor(ctx, state.Timeouts.Cluster+state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		task, err := getUpgradeTask(client, clusterID, taskID)
+		if err != nil {
+			return true, err
+		}
+		logrus.Infof("Upgrade task %s is in %s phase, progress: %s", taskID, task.Status.Phase, task.Status.Progress)
+		switch task.Status.Phase {
+		case upgradeTaskSuccess:
+			return true, nil
+		case upgradeTaskFailed:
+			return true, fmt.Errorf("upgrade task %s failed", taskID)
+		}
+		return false, nil
+	})
+}
+
+// waitForClusterNodesActive waits until all nodes of the cluster are active
+func waitForClusterNodesActive(ctx context.Context, client *services.Client, state *clusterState) error {
+	clusterID := state.ClusterID
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		nodeList, err := nodes.List(client.CCE, clusterID, nodes.ListOpts{})
+		if err != nil {
+			return true, err
+		}
+		for _, node := range nodeList {
+			if node.Status.Phase != services.NodeActive {
+				logrus.Debugf("Node %s is in %s phase", node.Metadata.Id, node.Status.Phase)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// waitForClusterAvailable waits until the cluster is in Available phase
+func waitForClusterAvailable(ctx context.Context, client *services.Client, state *clusterState) error {
+	clusterID := state.ClusterID
+	return waitFor(ctx, state.Timeouts.Cluster, state.Timeouts.PollInterval, func() (bool, error) {
+		cluster, err := clusters.Get(client.CCE, clusterID).Extract()
+		if err != nil {
+			return true, err
+		}
+		if cluster.Status.JobID != "" {
+			state.ClusterJobID = cluster.Status.JobID
+		}
+		trackJob(client, state, state.ClusterJobID)
+		switch cluster.Status.Phase {
+		case services.ClusterAvailable:
+			return true, nil
+		case "Error":
+			return true, fmt.Errorf("cluster %s is in error state: %s", clusterID, cluster.Status.Reason)
+		}
+		// the job can fail before the cluster phase is changed
+		if job, ok := state.Progress[state.ClusterJobID]; ok && job.Phase == jobPhaseFailed {
+			return true, fmt.Errorf("cluster %s job %s failed: %s", clusterID, state.ClusterJobID, job.Reason)
+		}
+		logrus.Debugf("Cluster %s is in %s phase", clusterID, cluster.Status.Phase)
+		return false, nil
+	})
+}
+
+// waitForNodesActive waits until all given nodes are active
+func waitForNodesActive(ctx context.Context, client *services.Client, state *clusterState, nodeIDs []string) error {
+	clusterID := state.ClusterID
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		for _, nodeID := range nodeIDs {
+			node, err := nodes.Get(client.CCE, clusterID, nodeID).Extract()
+			if err != nil {
+				return true, err
+			}
+			addNodeJob(state, node.Status.JobID)
+			if node.Status.Phase != services.NodeActive {
+				trackJob(client, state, node.Status.JobID)
+			}
+			if node.Status.Phase == "Error" {
+				return true, fmt.Errorf("node %s is in error state", nodeID)
+			}
+			if node.Status.Phase != services.NodeActive {
+				logrus.Debugf("Node %s is in %s phase", nodeID, node.Status.Phase)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// cancelledError is returned when an operation is stopped because its context is done
+func cancelledError(err error) error {
+	return fmt.Errorf("operation cancelled: %w", err)
+}
+
+// checkContext returns cancellation error if the context is done
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return cancelledError(err)
+	}
+	return nil
+}
+
+// waitFor calls `check` every `interval` seconds until it's done, `timeout` seconds are exceeded or
+// the context is done. Unlike golangsdk.WaitFor it stops waiting as soon as the context is cancelled
+func waitFor(ctx context.Context, timeout, interval int, check func() (bool, error)) error {
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return cancelledError(ctx.Err())
+		case <-deadline:
+			return fmt.Errorf("timeout of %d seconds exceeded", timeout)
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(golangsdk.ErrDefault404)
+	return ok
+}
+
+// deleteCluster deletes the cluster and waits until it is removed. Already removed cluster is not an error,
+// cluster being already deleted, e.g. after unsubscription of prepaid cluster, is only waited for
+func deleteCluster(ctx context.Context, client *services.Client, state *clusterState) error {
+	clusterID := state.ClusterID
+	cluster, err := clusters.Get(client.CCE, clusterID).Extract()
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cluster.Status.Phase != clusterPhaseDeleting {
+		if err := clusters.Delete(client.CCE, clusterID).Err; err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	logrus.Infof("Waiting for cluster %s to be deleted", clusterID)
+	return waitFor(ctx, state.Timeouts.Cluster, state.Timeouts.PollInterval, func() (bool, error) {
+		_, err := clusters.Get(client.CCE, clusterID).Extract()
+		if isNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		return false, nil
+	})
+}
+
+// deleteNodes deletes nodes and waits until they are removed, already removed nodes are skipped
+func deleteNodes(ctx context.Context, client *services.Client, state *clusterState, nodeIDs []string) error {
+	clusterID := state.ClusterID
+	for _, nodeID := range nodeIDs {
+		if err := nodes.Delete(client.CCE, clusterID, nodeID).Err; err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete node %s: %s", nodeID, err)
+		}
+	}
+	logrus.Infof("Waiting for nodes %v to be deleted", nodeIDs)
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		for _, nodeID := range nodeIDs {
+			_, err := nodes.Get(client.CCE, clusterID, nodeID).Extract()
+			if err == nil {
+				return false, nil
+			}
+			if !isNotFound(err) {
+				return true, err
+			}
+		}
+		return true, nil
+	})
+}
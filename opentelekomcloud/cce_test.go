@@ -0,0 +1,335 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteCluster(t *testing.T) {
+	phase := "Available"
+	deleted := false
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/projects/test/clusters/cluster-id", r.URL.Path)
+		requests = append(requests, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		if deleted {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{"error_code": "CCE.01404001"}`)
+			return
+		}
+		// deleting cluster is removed by CCE after the first check
+		if r.Method == http.MethodDelete || phase == clusterPhaseDeleting {
+			deleted = true
+		}
+		_, _ = fmt.Fprintf(w, `{"metadata": {"uid": "cluster-id"}, "status": {"phase": %q}}`, phase)
+	}))
+	defer server.Close()
+	client := fakeCCEClient(server.URL)
+	state := &clusterState{ClusterID: "cluster-id", Timeouts: DefaultTimeouts()}
+
+	require.NoError(t, deleteCluster(context.Background(), client, state))
+	assert.Equal(t, []string{http.MethodGet, http.MethodDelete, http.MethodGet}, requests)
+
+	requests = nil
+	require.NoError(t, deleteCluster(context.Background(), client, state), "removed cluster is not an error")
+	assert.Equal(t, []string{http.MethodGet}, requests)
+
+	// unsubscribed prepaid cluster is removed by CCE
+	requests = nil
+	deleted = false
+	phase = clusterPhaseDeleting
+	require.NoError(t, deleteCluster(context.Background(), client, state))
+	assert.NotContains(t, requests, http.MethodDelete)
+}
+
+func TestCleanupManagedResources(t *testing.T) {
+	deleted := map[string]bool{}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3/projects/test/clusters/cluster-id")
+		requests = append(requests, r.Method+" "+path)
+		w.Header().Set("Content-Type", "application/json")
+		if deleted[path] {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{"error_code": "CCE.01404001"}`)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			deleted[path] = true
+		}
+		_, _ = fmt.Fprint(w, `{"metadata": {"uid": "id"}, "status": {"phase": "Available"}}`)
+	}))
+	defer server.Close()
+
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		NodePools: []nodePool{
+			{Name: "pool-1", Type: nodePoolTypeVM, ID: "pool-id"},
+			{Name: "pool-2", Type: nodePoolTypeVM},
+		},
+		ManagedResources: managedResources{Cluster: true, Nodes: true},
+	}
+	require.NoError(t, cleanupManagedResources(context.Background(), fakeCCEClient(server.URL), state))
+	assert.Equal(t, []string{
+		"DELETE /nodepools/pool-id", "GET /nodepools/pool-id",
+		"GET ", "DELETE ", "GET ",
+	}, requests, "nodes are deleted before the cluster, not created pools are skipped")
+	assert.Equal(t, managedResources{}, state.ManagedResources)
+}
+
+func TestCleanupManagedResourcesErrors(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+	client := fakeCCEClient(server.URL)
+	client.VPC = &golangsdk.ServiceClient{
+		ProviderClient: &golangsdk.ProviderClient{HTTPClient: http.Client{}, ProjectID: "test"},
+		Endpoint:       server.URL + "/",
+	}
+
+	state := &clusterState{
+		VpcID:            "vpc-id",
+		SubnetID:         "subnet-id",
+		ManagedResources: managedResources{Subnet: true, Vpc: true},
+	}
+	err := cleanupManagedResources(context.Background(), client, state)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to delete subnet subnet-id")
+	assert.Contains(t, err.Error(), "failed to delete VPC vpc-id")
+	assert.Equal(t, []string{
+		"DELETE /test/vpcs/vpc-id/subnets/subnet-id", "DELETE /test/vpcs/vpc-id",
+	}, requests, "cleanup continues after failed step")
+	assert.Equal(t, managedResources{Subnet: true, Vpc: true}, state.ManagedResources)
+}
+
+func TestCreateWithRollback(t *testing.T) {
+	deleted := map[string]bool{}
+	var deletes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		request := r.Method + " " + r.URL.Path
+		switch {
+		case r.Method == http.MethodDelete:
+			deletes = append(deletes, request)
+			deleted[r.URL.Path] = true
+			w.WriteHeader(http.StatusNoContent)
+		// subnet is deleted by VPC path, but read by its own path
+		case deleted[r.URL.Path], deleted["/v1/test/vpcs/vpc-id"+strings.TrimPrefix(r.URL.Path, "/v1/test")]:
+			w.WriteHeader(http.StatusNotFound)
+		case request == "GET /api/v3/projects/test/clusters":
+			_, _ = fmt.Fprint(w, `{"items": []}`)
+		case request == "POST /api/v3/projects/test/clusters":
+			// the cluster request fails after network resources are created
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error_code": "CCE.01400001"}`)
+		case request == "GET /v1/test/vpcs":
+			_, _ = fmt.Fprint(w, `{"vpcs": []}`)
+		case request == "POST /v1/test/vpcs":
+			_, _ = fmt.Fprint(w, `{"vpc": {"id": "vpc-id", "status": "CREATING"}}`)
+		case request == "GET /v1/test/vpcs/vpc-id":
+			_, _ = fmt.Fprint(w, `{"vpc": {"id": "vpc-id", "status": "OK"}}`)
+		case request == "GET /v1/test/subnets":
+			_, _ = fmt.Fprint(w, `{"subnets": []}`)
+		case request == "POST /v1/test/subnets":
+			_, _ = fmt.Fprint(w, `{"subnet": {"id": "subnet-id", "status": "UNKNOWN"}}`)
+		case request == "GET /v1/test/subnets/subnet-id":
+			_, _ = fmt.Fprint(w, `{"subnet": {"id": "subnet-id", "status": "ACTIVE"}}`)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/tags/action"):
+			w.WriteHeader(http.StatusNoContent)
+		case request == "GET /v1/test/bandwidths":
+			_, _ = fmt.Fprint(w, `{"bandwidths": []}`)
+		case request == "POST /v1/test/publicips":
+			_, _ = fmt.Fprint(w, `{"publicip": {"id": "eip-id", "public_ip_address": "80.158.1.1"}}`)
+		case request == "GET /compute/os-floating-ips":
+			_, _ = fmt.Fprint(w, `{"floating_ips": [{"id": "eip-id", "ip": "80.158.1.1"}]}`)
+		default:
+			t.Fatalf("unexpected request %s", request)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+	client.ComputeV2 = &golangsdk.ServiceClient{
+		ProviderClient: client.CCE.ProviderClient,
+		Endpoint:       server.URL + "/",
+		ResourceBase:   server.URL + "/compute/",
+	}
+
+	state := &clusterState{
+		ClusterName: "test",
+		VpcName:     "test-vpc",
+		SubnetName:  "test-subnet",
+		Timeouts:    DefaultTimeouts(),
+	}
+	info, err := createWithRollback(context.Background(), client, state, &types.ClusterInfo{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create cluster")
+	assert.Equal(t, []string{
+		"DELETE /compute/os-floating-ips/eip-id",
+		"DELETE /v1/test/vpcs/vpc-id/subnets/subnet-id",
+		"DELETE /v1/test/vpcs/vpc-id",
+	}, deletes, "cluster EIP, subnet and VPC created by failed Create are removed")
+	assert.Equal(t, managedResources{}, state.ManagedResources)
+	require.NotNil(t, info, "info is returned with the error")
+	assert.Contains(t, info.Metadata["state"], `"ClusterName":"test"`)
+}
+
+func TestWaitFor(t *testing.T) {
+	calls := 0
+	require.NoError(t, waitFor(context.Background(), 10, 0, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	}))
+	assert.Equal(t, 3, calls)
+
+	checkErr := fmt.Errorf("check failed")
+	assert.Equal(t, checkErr, waitFor(context.Background(), 10, 0, func() (bool, error) {
+		return true, checkErr
+	}))
+
+	err := waitFor(context.Background(), 0, 10, func() (bool, error) {
+		return false, nil
+	})
+	assert.EqualError(t, err, "timeout of 0 seconds exceeded")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = waitFor(ctx, 60, 60, func() (bool, error) {
+		calls++
+		cancel()
+		return false, nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+
+	err = waitFor(ctx, 60, 60, func() (bool, error) {
+		t.Fatal("check is not called for cancelled context")
+		return false, nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWaitForClusterAvailableFailedJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v3/projects/test/clusters/cluster-id":
+			_, _ = fmt.Fprint(w, `{"metadata": {"uid": "cluster-id"}, "status": {"phase": "Creating", "jobID": "job-id"}}`)
+		case "/api/v3/projects/test/jobs/job-id":
+			_, _ = fmt.Fprint(w, `{"spec": {"type": "CreateCluster"}, "status": {"phase": "Failed", "reason": "quota exceeded"}}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	state := &clusterState{ClusterID: "cluster-id", Timeouts: Timeouts{Cluster: 60}}
+	err := waitForClusterAvailable(context.Background(), fakeCCEClient(server.URL), state)
+	assert.EqualError(t, err, "cluster cluster-id job job-id failed: quota exceeded")
+	assert.Equal(t, "job-id", state.ClusterJobID)
+}
+
+func TestUpgradeCluster(t *testing.T) {
+	taskPhases := []string{"Running", upgradeTaskSuccess}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3/projects/test/clusters/cluster-id")
+		requests = append(requests, r.Method+" "+path)
+		w.Header().Set("Content-Type", "application/json")
+		switch path {
+		case "":
+			_, _ = fmt.Fprint(w, `{"metadata": {"uid": "cluster-id"}, "spec": {"version": "v1.25.3-r0"}}`)
+		case "/operation/upgrade":
+			_, _ = fmt.Fprint(w, `{"metadata": {"uid": "task-id"}}`)
+		case "/operation/upgrade/tasks/task-id":
+			phase := taskPhases[0]
+			if len(taskPhases) > 1 {
+				taskPhases = taskPhases[1:]
+			}
+			_, _ = fmt.Fprintf(w, `{"metadata": {"uid": "task-id"}, "status": {"phase": %q}}`, phase)
+		case "/nodes":
+			_, _ = fmt.Fprint(w, `{"items": [{"metadata": {"uid": "node-1"}, "status": {"phase": "Active"}}]}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := fakeCCEClient(server.URL)
+	kubeNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "192.168.0.10"},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{KubeletVersion: "v1.27.4-r0"}},
+	}
+	clientSet := fake.NewSimpleClientset(kubeNode)
+	catalog := &clusterCatalog{Versions: []string{"v1.23", "v1.25", "v1.27", "v1.28"}}
+	state := &clusterState{ClusterID: "cluster-id", ClusterVersion: "v1.25", Timeouts: Timeouts{Nodes: 60, PollInterval: 0}}
+	ctx := context.Background()
+
+	require.NoError(t, upgradeCluster(ctx, client, clientSet, state, catalog, "v1.25"))
+	assert.Equal(t, []string{"GET "}, requests, "cluster already running the version isn't upgraded")
+	assert.Equal(t, "v1.25", state.ClusterVersion)
+
+	requests = nil
+	err := upgradeCluster(ctx, client, clientSet, state, catalog, "v1.23")
+	assert.EqualError(t, err, "cluster version v1.25.3-r0 can't be downgraded to v1.23")
+	assert.Equal(t, []string{"GET "}, requests)
+
+	requests = nil
+	err = upgradeCluster(ctx, client, clientSet, state, catalog, "v1.28")
+	assert.EqualError(t, err, "cluster version v1.25.3-r0 can be upgraded only to the next version v1.27, not to v1.28")
+	assert.Equal(t, []string{"GET "}, requests, "upgrade skipping versions isn't started")
+
+	requests = nil
+	require.NoError(t, upgradeCluster(ctx, client, clientSet, state, catalog, "v1.27"))
+	assert.Equal(t, []string{
+		"GET ", "POST /operation/upgrade",
+		"GET /operation/upgrade/tasks/task-id", "GET /operation/upgrade/tasks/task-id",
+		"GET /nodes",
+	}, requests)
+	assert.Equal(t, "v1.27", state.ClusterVersion)
+
+	taskPhases = []string{upgradeTaskFailed}
+	state.ClusterVersion = "v1.25"
+	err = upgradeCluster(ctx, client, clientSet, state, catalog, "v1.27")
+	assert.EqualError(t, err, "failed to upgrade cluster: upgrade task task-id failed")
+	assert.Equal(t, "v1.25", state.ClusterVersion, "version isn't changed by failed upgrade")
+
+	taskPhases = []string{upgradeTaskSuccess}
+	kubeNode.Status.NodeInfo.KubeletVersion = "v1.25.3-r0"
+	state.Timeouts.Nodes = 0
+	err = upgradeCluster(ctx, client, fake.NewSimpleClientset(kubeNode), state, catalog, "v1.27")
+	assert.Error(t, err, "nodes are expected to run the target version")
+	assert.Equal(t, "v1.25", state.ClusterVersion)
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.25", "v1.25.3-r0", 0},
+		{"1.27", "v1.25", 1},
+		{"v1.9", "v1.25", -1},
+		{"v2.1", "v1.28", 1},
+	} {
+		result, err := compareVersions(c.a, c.b)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, result, "%s vs %s", c.a, c.b)
+	}
+	_, err := compareVersions("v1", "v1.25")
+	assert.Error(t, err)
+}
@@ -4,32 +4,31 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/getlantern/deepcopy"
 	"github.com/opentelekomcloud-infra/crutch-house/services"
-	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
 	"github.com/opentelekomcloud/gophertelekomcloud/openstack"
 	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodepools"
 	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
 	"github.com/rancher/kontainer-engine/drivers/util"
 	"github.com/rancher/kontainer-engine/types"
 	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-const (
-	retries      = 5
-	pollInterval = 30
-)
-
 var (
 	clusterVersions = []string{
 		"v1.23",
 		"v1.25",
+		"v1.27",
+		"v1.28",
 	}
 	clusterFlavors = []string{
 		"cce.s1.small",
@@ -39,15 +38,28 @@ var (
 		"cce.s2.medium",
 		"cce.s2.large",
 		"cce.s2.xlarge",
+		"cce.t1.small",
+		"cce.t1.medium",
+		"cce.t1.large",
+		"cce.t2.small",
+		"cce.t2.medium",
+		"cce.t2.large",
 	}
 )
 
 type managedResources struct {
-	Vpc        bool
-	Subnet     bool
-	Cluster    bool
-	Nodes      bool
-	ClusterEip bool
+	Vpc           bool
+	Subnet        bool
+	HighwaySubnet bool
+	Cluster       bool
+	Nodes         bool
+	ClusterEip    bool
+	NatGateway    bool
+	NatEip        bool
+	SnatRule      bool
+	SecurityGroup bool
+	// NodeEips are IDs of EIPs created for cluster nodes
+	NodeEips []string
 }
 
 type clusterState struct {
@@ -60,33 +72,63 @@ type clusterState struct {
 	Region                string
 	ClusterType           string
 	ClusterFlavor         string
+	ClusterVersion        string
 	ClusterBillingMode    int
+	MasterAZs             []string
 	ClusterLabels         map[string]string
 	ContainerNetworkMode  string
 	ContainerNetworkCidr  string
+	EniSubnetIDs          []string
+	EniSubnetCIDRs        []string
 	VpcID                 string
 	VpcName               string
 	SubnetID              string
 	SubnetName            string
 	HighwaySubnetID       string
 	HighwaySubnetName     string
+	HighwaySubnetCIDR     string
 	AuthenticatingProxyCa string
 	UseFloatingIP         bool
 	ClusterFloatingIP     string
 	ClusterEIPOptions     services.ElasticIPOpts
 	ClusterJobID          string
+	LoadBalancerID        string
+	CreateNatGateway      bool
+	NatGatewaySpec        string
+	NatGatewayID          string
+	NatEipID              string
+	SnatRuleID            string
+	SecurityGroups        []string
+	SecurityGroupIDs      []string
+	SecurityGroupRules    []securityGroupRule
+	SecurityGroupID       string // security group created by the driver for SecurityGroupRules
 	NodeConfig            services.CreateNodesOpts
-	NodeIDs               []string
+	NodeLabels            map[string]string
+	NodeTaints            []nodes.TaintSpec
+	NodeBandwidthID       string
+	NodePools             []nodePool
+	NodeIDs               []string // nodes created without node pool by previous driver versions, see migrateLegacyNodes
+	NodeJobIDs            []string // jobs of nodes created by the last operation
 	AuthMode              string
+	Period                periodOpts
+	Backup                backupOpts
+	Progress              map[string]jobProgress // progress of CCE jobs of the last operation
+	TimeoutOptions        Timeouts               `json:"Timeouts"` // timeouts set in cluster options, not set values are 0
+	Timeouts              Timeouts               `json:"-"`        // effective timeouts, see CCEDriver.resolveTimeouts
+	RollingUpdate         rollingUpdate
+	CreationToken         string // marks the CCE cluster created by Create, see adoptCluster
 	ManagedResources      managedResources
 }
 
 type CCEDriver struct {
 	driverCapabilities types.Capabilities
+	// timeouts are used for options which are not set for the cluster
+	timeouts Timeouts
 }
 
 func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags, error) {
 	logrus.Info("Getting driver create opts...")
+	catalog := staticCatalog
 	flags := &types.DriverFlags{
 		Options: map[string]*types.Flag{
 			// Cluster general options
@@ -157,25 +199,30 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 			},
 			"cluster-version": {
 				Type:  types.StringType,
-				Usage: fmt.Sprintf("Version of k8s (one of %s), default is latest available", strings.Join(clusterVersions, ", ")),
+				Usage: fmt.Sprintf("Version of k8s (one of %s), default is latest available", strings.Join(catalog.Versions, ", ")),
 			},
 			"cluster-flavor": {
-				Type:  types.StringType,
-				Usage: "Cluster flavor, one of " + strings.Join(clusterFlavors, ", "),
+				Type: types.StringType,
+				Usage: "Cluster flavor, one of " + strings.Join(catalog.Flavors, ", ") +
+					"; cce.t* flavors are used by BareMetal clusters",
 				Default: &types.Default{
 					DefaultString: "cce.s2.small",
 				},
 			},
+			"master-availability-zones": {
+				Type:  types.StringSliceType,
+				Usage: "Availability zones of cluster masters: single AZ, or 3 AZs to spread masters of cce.s2 and cce.t2 flavors",
+			},
 			"cluster-billing-mode": {
 				Type:  types.IntType,
-				Usage: "The bill mode of the cluster",
+				Usage: "The bill mode of the cluster, 0 for on-demand, 1 for prepaid",
 				Default: &types.Default{
 					DefaultInt: 0,
 				},
 			},
 			"cluster-labels": {
 				Type:  types.StringSliceType,
-				Usage: "The map of Kubernetes labels (key/value pairs) to be applied to cluster",
+				Usage: "The map of Kubernetes labels (key/value pairs) to be applied to cluster and its nodes",
 			},
 			// cluster networking
 			"vpc": {
@@ -196,13 +243,30 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 			},
 			"highway-subnet": {
 				Type:  types.StringType,
-				Usage: "The id of existing highway subnet when the cluster-type is BareMetal",
+				Usage: "The name of highway subnet when the cluster-type is BareMetal, created if doesn't exist",
+			},
+			"highway-subnet-id": {
+				Type:  types.StringType,
+				Usage: "The ID of existing highway subnet when the cluster-type is BareMetal",
+			},
+			"highway-subnet-cidr": {
+				Type:    types.StringType,
+				Usage:   "The CIDR of created highway subnet",
+				Default: &types.Default{DefaultString: "192.168.1.0/24"},
 			},
 			"container-network-mode": {
 				Type:  types.StringType,
-				Usage: "The network mode of container",
+				Usage: "The network mode of container, one of overlay_l2, vpc-router, underlay_ipvlan (BareMetal), eni (CCE Turbo)",
 				Value: "overlay_l2",
 			},
+			"eni-subnet-ids": {
+				Type:  types.StringSliceType,
+				Usage: "IPv4 subnet IDs in the cluster VPC used for containers in eni network mode",
+			},
+			"eni-subnet-cidrs": {
+				Type:  types.StringSliceType,
+				Usage: "CIDRs of eni-subnet-ids subnets in the same order",
+			},
 			"container-network-cidr": {
 				Type:  types.StringType,
 				Usage: "The network cidr of container",
@@ -222,6 +286,27 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 				Type:  types.StringType,
 				Usage: "The CA for authenticating proxy, it is required if authentication-mode is authenticating_proxy",
 			},
+			"create-nat-gateway": {
+				Type:  types.BoolType,
+				Usage: "Create NAT gateway with SNAT rule giving cluster nodes access to the internet",
+			},
+			"nat-gateway-spec": {
+				Type:    types.StringType,
+				Usage:   "The spec of created NAT gateway: 1 (small), 2 (medium), 3 (large), 4 (extra-large)",
+				Default: &types.Default{DefaultString: "1"},
+			},
+			"security-groups": {
+				Type: types.StringSliceType,
+				Usage: "Names or IDs of existing security groups attached to nodes instead of the default node security group. " +
+					"Together with the security-group-rules group they should allow traffic required by CCE: " +
+					"all traffic within the VPC and container CIDRs, TCP 10250 from masters, UDP 4789 for overlay_l2 network " +
+					"and TCP/UDP 30000-32767 for node ports",
+			},
+			"security-group-rules": {
+				Type: types.StringSliceType,
+				Usage: "Ingress rules in `protocol:port[-port]:cidr` format, e.g. `tcp:30000-32767:192.0.2.0/24`. " +
+					"Security group with the rules is created and attached to nodes instead of the default node security group if set",
+			},
 			"cluster-floating-ip": {
 				Type:  types.StringType,
 				Usage: "Existing floating IP to be associated with cluster master node",
@@ -256,25 +341,71 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 				Type:  types.StringType,
 				Usage: "The name of ssh key-pair",
 			},
+			"node-labels": {
+				Type:  types.StringSliceType,
+				Usage: "Kubernetes labels of worker nodes in `key=value` format, cluster labels are applied to nodes as well",
+			},
+			"node-taints": {
+				Type:  types.StringSliceType,
+				Usage: "Kubernetes taints of worker nodes in `key=value:Effect` format, effect is one of NoSchedule, PreferNoSchedule, NoExecute",
+			},
+			"node-preinstall-script": {
+				Type:  types.StringType,
+				Usage: "Script executed on each node before Kubernetes components are installed",
+			},
+			"node-postinstall-script": {
+				Type:  types.StringType,
+				Usage: "Script executed on each node after Kubernetes components are installed",
+			},
+			"node-eip": {
+				Type:  types.BoolType,
+				Usage: "Assign EIP to each node, the EIPs are released on cluster removal",
+			},
+			"node-eip-type": {
+				Type:    types.StringType,
+				Usage:   "The type of node EIPs",
+				Default: &types.Default{DefaultString: "5_bgp"},
+			},
+			"node-eip-bandwidth-size": {
+				Type:    types.IntType,
+				Usage:   "The size of node EIP bandwidth, MBit",
+				Default: &types.Default{DefaultInt: 100},
+			},
+			"node-eip-share-type": {
+				Type:    types.StringType,
+				Usage:   "The share type of node EIP bandwidth, PER (dedicated) or WHOLE (shared)",
+				Default: &types.Default{DefaultString: "PER"},
+			},
+			"node-eip-bandwidth-id": {
+				Type:  types.StringType,
+				Usage: "ID of shared bandwidth used by node EIPs, it is required if node-eip-share-type is WHOLE",
+			},
+			"node-pools": {
+				Type: types.StringSliceType,
+				Usage: "Node pools in `name=<name>,flavor=<flavor>,az=<az>,count=<count>` format, " +
+					"root-volume-size, root-volume-type, data-volume-size and data-volume-type can be set as well. " +
+					"Pool node labels and taints are set as `labels=key=value;key=value` and `taints=key=value:Effect;key:Effect`. " +
+					"Node options are used for missing values, single pool is created if not set",
+			},
 			// BMS settings
 			"billing-mode": {
 				Type:    types.IntType,
-				Usage:   "The bill mode of the nodes",
+				Usage:   "The bill mode of the nodes, 0 for on-demand, 1 for prepaid. BMS nodes are always prepaid",
 				Default: &types.Default{DefaultInt: 0},
 			},
 			"bms-period-type": {
 				Type:    types.StringType,
-				Usage:   "The period type",
+				Usage:   "The period type of prepaid cluster and nodes, month or year",
 				Default: &types.Default{DefaultString: "month"},
 			},
 			"bms-period-num": {
 				Type:    types.IntType,
-				Usage:   "The number of period",
+				Usage:   "The number of periods of prepaid cluster and nodes, 1-9 months or 1-3 years",
 				Default: &types.Default{DefaultInt: 1},
 			},
 			"bms-auto-renew": {
 				Type:  types.BoolType,
-				Usage: "If the period is auto renew",
+				Usage: "If the period of prepaid cluster and nodes is auto renew",
 			},
 			// disk settings
 			"root-volume-size": {
@@ -284,7 +415,7 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 			},
 			"root-volume-type": {
 				Type:    types.StringType,
-				Usage:   "Type of the system disk attached to each node, one of SATA, SAS, SSD",
+				Usage:   "Type of the system disk attached to each node, one of SATA, SAS, SSD, GPSSD, ESSD",
 				Default: &types.Default{DefaultString: "SATA"},
 			},
 			"data-volume-size": {
@@ -294,9 +425,29 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 			},
 			"data-volume-type": {
 				Type:    types.StringType,
-				Usage:   "Type of the data disk attached to each node, one of SATA, SAS, SSD",
+				Usage:   "Type of the data disk attached to each node, one of SATA, SAS, SSD, GPSSD, ESSD",
 				Default: &types.Default{DefaultString: "SATA"},
 			},
+			"data-volumes": {
+				Type: types.StringSliceType,
+				Usage: "Data disks attached to each node in `size=<size>,type=<type>,kms-key-id=<id>` format, " +
+					"kms-key-id is optional, data-volume-size and data-volume-type are used for missing values. " +
+					"Single data disk is attached if not set",
+			},
+			"root-volume-kms-key-id": {
+				Type:  types.StringType,
+				Usage: "ID of KMS key used to encrypt the system disk of each node, the disk is not encrypted if not set",
+			},
+			"rolling-surge": {
+				Type:    types.IntType,
+				Usage:   "Number of nodes which can be created above the node pool size when nodes are replaced by update",
+				Default: &types.Default{DefaultInt: defaultRollingSurge},
+			},
+			"rolling-max-unavailable": {
+				Type:    types.IntType,
+				Usage:   "Number of nodes which can be unavailable when nodes are replaced by update",
+				Default: &types.Default{DefaultInt: defaultRollingMaxUnavailable},
+			},
 			// master node bandwidth
 			"cluster-eip-type": {
 				Type:    types.StringType,
@@ -313,14 +464,44 @@ func (d *CCEDriver) GetDriverCreateOptions(context.Context) (*types.DriverFlags,
 				Usage:   "The share type of bandwidth",
 				Default: &types.Default{DefaultString: "PER"},
 			},
+			// snapshots
+			"backup-bucket": {
+				Type:  types.StringType,
+				Usage: "Name of existing OBS bucket used for storing cluster resource snapshots",
+			},
+			"backup-obs-endpoint": {
+				Type:  types.StringType,
+				Usage: "OBS endpoint used for snapshots, default is OBS endpoint of the region",
+			},
+			"backup-access-key": {
+				Type:     types.StringType,
+				Usage:    "Access key ID used for OBS access, access-key is used if not set",
+				Password: true,
+			},
+			"backup-secret-key": {
+				Type:     types.StringType,
+				Usage:    "Secret access key used for OBS access, secret-key is used if not set",
+				Password: true,
+			},
+			"backup-retention-count": {
+				Type:  types.IntType,
+				Usage: "Number of latest snapshots kept in OBS after each save, 0 keeps all snapshots",
+			},
+			"backup-retention-days": {
+				Type:  types.IntType,
+				Usage: "Snapshots older than this number of days are removed from OBS after each save, 0 keeps all snapshots",
+			},
 			// lb
 			"load-balancer": {
 				Type:    types.StringType,
-				Usage:   "Existing LB ID",
+				Usage:   "Existing shared ELB ID in the cluster VPC used by LoadBalancer services without kubernetes.io/elb.id or kubernetes.io/elb.autocreate annotation",
 				Default: &types.Default{DefaultString: ""},
 			},
 		},
 	}
+	for name, flag := range timeoutFlags() {
+		flags.Options[name] = flag
+	}
 	return flags, nil
 }
 
@@ -332,8 +513,65 @@ func (d *CCEDriver) GetDriverUpdateOptions(context.Context) (*types.DriverFlags,
 				Type:  types.StringType,
 				Usage: "Cluster description",
 			},
+			"cluster-labels": {
+				Type:  types.StringSliceType,
+				Usage: "The map of Kubernetes labels (key/value pairs) to be applied to cluster and its nodes",
+			},
+			// Nodes configuration
+			"node-labels": {
+				Type:  types.StringSliceType,
+				Usage: "Kubernetes labels of worker nodes in `key=value` format, cluster labels are applied to nodes as well",
+			},
+			"node-taints": {
+				Type:  types.StringSliceType,
+				Usage: "Kubernetes taints of worker nodes in `key=value:Effect` format, effect is one of NoSchedule, PreferNoSchedule, NoExecute",
+			},
+			// Nodes changed by rolling replacement
+			"node-flavor": {
+				Type:  types.StringType,
+				Usage: "The node flavor, nodes are replaced if changed",
+			},
+			"node-os": {
+				Type:  types.StringType,
+				Usage: "The operation system of nodes, nodes are replaced if changed",
+			},
+			"node-pools": {
+				Type:  types.StringSliceType,
+				Usage: "Node pools in the create option format. Pools are matched by name and scaled, created or deleted, nodes of pools with changed flavor or volumes are replaced, changed pool labels and taints are applied to the pool nodes",
+			},
+			"root-volume-size": {
+				Type:  types.IntType,
+				Usage: "Size of the system disk attached to each node in GB, nodes are replaced if changed",
+			},
+			"root-volume-type": {
+				Type:  types.StringType,
+				Usage: "Type of the system disk attached to each node, nodes are replaced if changed",
+			},
+			"data-volume-size": {
+				Type:  types.IntType,
+				Usage: "Size of the data disk attached to each node in GB, nodes are replaced if changed",
+			},
+			"data-volume-type": {
+				Type:  types.StringType,
+				Usage: "Type of the data disk attached to each node, nodes are replaced if changed",
+			},
+			"data-volumes": {
+				Type:  types.StringSliceType,
+				Usage: "Data disks attached to each node in the create option format, nodes are replaced if changed",
+			},
+			"rolling-surge": {
+				Type:  types.IntType,
+				Usage: "Number of nodes which can be created above the node pool size during node replacement",
+			},
+			"rolling-max-unavailable": {
+				Type:  types.IntType,
+				Usage: "Number of nodes which can be unavailable during node replacement",
+			},
 		},
 	}
+	for name, flag := range timeoutFlags() {
+		flags.Options[name] = flag
+	}
 	return flags, nil
 }
 
@@ -354,14 +592,20 @@ func optsToString(opts *types.DriverOptions) string {
 	if opts2.StringOptions["token"] != "" {
 		opts2.StringOptions["token"] = "***"
 	}
+	if opts2.StringOptions["backupAccessKey"] != "" {
+		opts2.StringOptions["backupAccessKey"] = "***"
+	}
+	if opts2.StringOptions["backupSecretKey"] != "" {
+		opts2.StringOptions["backupSecretKey"] = "***"
+	}
 	return fmt.Sprintf("%v", opts2)
 }
 
-func optsToState(opts *types.DriverOptions) (*clusterState, error) {
+func optsToState(opts *types.DriverOptions) (state *clusterState, err error) {
 	logrus.Info("Start setting state from provided opts: \n", optsToString(opts))
 	strOpt, strSliceOpt, intOpt, boolOpt := getters(opts)
 	projectName := strOpt("project-name", "projectName")
-	state := &clusterState{
+	state = &clusterState{
 		AuthInfo: openstack.AuthInfo{
 			AuthURL:     strOpt("auth-url", "authUrl"),
 			Token:       strOpt("token"),
@@ -379,10 +623,13 @@ func optsToState(opts *types.DriverOptions) (*clusterState, error) {
 		Region:                strOpt("region"),
 		ClusterType:           strOpt("cluster-type", "clusterType"),
 		ClusterFlavor:         strOpt("cluster-flavor", "clusterFlavor"),
+		ClusterVersion:        normalizeVersion(strOpt("cluster-version", "clusterVersion")),
 		ClusterBillingMode:    int(intOpt("cluster-billing-mode", "clusterBillingMode")),
-		ClusterLabels:         map[string]string{},
+		MasterAZs:             strSliceOpt("master-availability-zones", "masterAvailabilityZones"),
 		ContainerNetworkMode:  strOpt("container-network-mode", "containerNetworkMode"),
 		ContainerNetworkCidr:  strOpt("container-network-cidr", "containerNetworkCidr"),
+		EniSubnetIDs:          strSliceOpt("eni-subnet-ids", "eniSubnetIds"),
+		EniSubnetCIDRs:        strSliceOpt("eni-subnet-cidrs", "eniSubnetCidrs"),
 		AuthenticatingProxyCa: strOpt("auth-proxy-ca", "authProxyCa"),
 		UseFloatingIP:         !boolOpt("no-floating-ip", "noFloatingIp"),
 		ClusterFloatingIP:     strOpt("cluster-floating-ip", "clusterFloatingIp"),
@@ -401,14 +648,15 @@ func optsToState(opts *types.DriverOptions) (*clusterState, error) {
 				Size:       int(intOpt("root-volume-size", "rootVolumeSize")),
 				VolumeType: strOpt("root-volume-type", "rootVolumeType"),
 			},
-			DataVolumes: []nodes.VolumeSpec{
-				{
-					Size:       int(intOpt("data-volume-size", "dataVolumeSize")),
-					VolumeType: strOpt("data-volume-type", "dataVolumeType"),
-				},
+			Os:          strOpt("node-os", "os", "nodeOs"),
+			BillingMode: int(intOpt("billing-mode", "billingMode")),
+			PreInstall:  strOpt("node-preinstall-script", "nodePreinstallScript"),
+			PostInstall: strOpt("node-postinstall-script", "nodePostinstallScript"),
+			EipOpts: services.ElasticIPOpts{
+				IPType:        strOpt("node-eip-type", "nodeEipType"),
+				BandwidthSize: int(intOpt("node-eip-bandwidth-size", "nodeEipBandwidthSize")),
+				BandwidthType: strOpt("node-eip-share-type", "nodeEipShareType"),
 			},
-			Os:       strOpt("node-os", "os", "nodeOs"),
-			EipCount: 0,
 		},
 		AuthMode:          strOpt("auth-mode", "authenticationMode"),
 		VpcName:           strOpt("vpc", "vpcName"),
@@ -416,14 +664,80 @@ func optsToState(opts *types.DriverOptions) (*clusterState, error) {
 		SubnetName:        strOpt("subnet", "subnetName"),
 		SubnetID:          strOpt("subnet-id", "subnetId"),
 		HighwaySubnetName: strOpt("highway-subnet", "highwaySubnetName"),
+		CreateNatGateway:  boolOpt("create-nat-gateway", "createNatGateway"),
+		NatGatewaySpec:    strOpt("nat-gateway-spec", "natGatewaySpec"),
+		SecurityGroups:    strSliceOpt("security-groups", "securityGroups"),
+		HighwaySubnetID:   strOpt("highway-subnet-id", "highwaySubnetId"),
+		HighwaySubnetCIDR: strOpt("highway-subnet-cidr", "highwaySubnetCidr"),
+		LoadBalancerID:    strOpt("load-balancer", "loadBalancer"),
+		Period: periodOpts{
+			PeriodType: strOpt("bms-period-type", "bmsPeriodType"),
+			PeriodNum:  int(intOpt("bms-period-num", "bmsPeriodNum")),
+			AutoRenew:  boolOpt("bms-auto-renew", "bmsAutoRenew"),
+		},
+		Backup: backupOpts{
+			Bucket:    strOpt("backup-bucket", "backupBucket"),
+			Endpoint:  strOpt("backup-obs-endpoint", "backupObsEndpoint"),
+			AccessKey: strOpt("backup-access-key", "backupAccessKey"),
+			SecretKey: strOpt("backup-secret-key", "backupSecretKey"),
+
+			RetentionCount: int(intOpt("backup-retention-count", "backupRetentionCount")),
+			RetentionDays:  int(intOpt("backup-retention-days", "backupRetentionDays")),
+		},
 	}
 
-	for _, label := range strSliceOpt("cluster-labels", "clusterLabels") {
-		lab := strings.Split(label, "=")
-		if len(lab) != 2 {
-			return nil, fmt.Errorf("invalid label value: %s", label)
-		}
-		state.ClusterLabels[lab[0]] = lab[1]
+	if state.ContainerNetworkMode == "" {
+		state.ContainerNetworkMode = services.ContainerNetworkModeOverlay
+	}
+
+	poolType := nodePoolTypeVM
+	if state.ClusterType == services.ClusterTypeBMS {
+		poolType = nodePoolTypeBMS
+		state.NodeConfig.BillingMode = billingModePrepaid
+	}
+	if state.NodeConfig.BillingMode == billingModePrepaid {
+		state.NodeConfig.ChargingMode = chargingModePrepaid
+	}
+	if boolOpt("node-eip", "nodeEip") {
+		state.NodeConfig.EipCount = 1
+	}
+	state.NodeBandwidthID = strOpt("node-eip-bandwidth-id", "nodeEipBandwidthId")
+	encryptVolume(&state.NodeConfig.RootVolume, strOpt("root-volume-kms-key-id", "rootVolumeKmsKeyId"))
+	state.NodeConfig.DataVolumes, err = parseDataVolumes(strSliceOpt("data-volumes", "dataVolumes"), nodes.VolumeSpec{
+		Size:       int(intOpt("data-volume-size", "dataVolumeSize")),
+		VolumeType: strOpt("data-volume-type", "dataVolumeType"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.NodePools, err = parseNodePools(strSliceOpt("node-pools", "nodePools"), nodePool{
+		Type:             poolType,
+		FlavorID:         state.NodeConfig.FlavorID,
+		AvailabilityZone: state.NodeConfig.AvailabilityZone,
+		RootVolume:       state.NodeConfig.RootVolume,
+		DataVolumes:      state.NodeConfig.DataVolumes,
+		Count:            int(intOpt("node-count", "nodeCount")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if state.ClusterLabels, err = parseLabels(strSliceOpt("cluster-labels", "clusterLabels")); err != nil {
+		return nil, err
+	}
+	if state.NodeLabels, err = parseLabels(strSliceOpt("node-labels", "nodeLabels")); err != nil {
+		return nil, err
+	}
+	if state.NodeTaints, err = parseTaints(strSliceOpt("node-taints", "nodeTaints")); err != nil {
+		return nil, err
+	}
+	if state.SecurityGroupRules, err = parseSecurityGroupRules(strSliceOpt("security-group-rules", "securityGroupRules")); err != nil {
+		return nil, err
+	}
+	state.TimeoutOptions = timeoutsFromOpts(opts)
+	state.RollingUpdate = rollingUpdate{
+		Surge:          int(intOpt("rolling-surge", "rollingSurge")),
+		MaxUnavailable: int(intOpt("rolling-max-unavailable", "rollingMaxUnavailable")),
 	}
 
 	return state, nil
@@ -435,8 +749,10 @@ func infoToState(info *types.ClusterInfo) (*clusterState, error) {
 	err := json.Unmarshal([]byte(info.Metadata["state"]), state)
 	if err != nil {
 		logrus.WithError(err).Error("error encountered while marshalling state")
+		return state, err
 	}
-	return state, err
+	migrateLegacyNodes(state)
+	return state, nil
 }
 
 // Save state to ClusterInfo Metadata. Update `info` in-place
@@ -449,11 +765,16 @@ func stateToInfo(state *clusterState, info *types.ClusterInfo) (*types.ClusterIn
 		info.Metadata = map[string]string{}
 	}
 	info.Metadata["state"] = string(data)
+	if summary := progressSummary(state); summary != "" {
+		info.Metadata[progressMetadataKey] = summary
+	} else {
+		delete(info.Metadata, progressMetadataKey)
+	}
 
 	return info, nil
 }
 
-func setupNetwork(client *services.Client, state *clusterState) error {
+func setupNetwork(ctx context.Context, client *services.Client, state *clusterState) error {
 	logrus.Debug("Setup network process started")
 	if state.VpcID == "" && state.VpcName != "" {
 		vpcID, err := client.FindVPC(state.VpcName)
@@ -467,12 +788,20 @@ func setupNetwork(client *services.Client, state *clusterState) error {
 			}
 			state.ManagedResources.Vpc = true
 			vpcID = vpc.ID
+			if err := tagNetworkResource(client, state, resourceTypeVpc, vpcID); err != nil {
+				return err
+			}
+		} else if err := adoptNetworkResource(client, state, &state.ManagedResources.Vpc, resourceTypeVpc, vpcID); err != nil {
+			return err
 		}
-		if err := client.WaitForVPCStatus(vpcID, "OK"); err != nil {
+		if err := waitForVPCStatus(ctx, client, state, vpcID, "OK"); err != nil {
 			return fmt.Errorf("failed waiting for VPC status 'OK': %s", err)
 		}
 		state.VpcID = vpcID
 	}
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
 
 	if state.SubnetID == "" && state.SubnetName != "" {
 		subnetID, err := client.FindSubnet(state.VpcID, state.SubnetName)
@@ -486,78 +815,190 @@ func setupNetwork(client *services.Client, state *clusterState) error {
 			}
 			state.ManagedResources.Subnet = true
 			subnetID = subnet.ID
+			if err := tagNetworkResource(client, state, resourceTypeSubnet, subnetID); err != nil {
+				return err
+			}
+		} else if err := adoptNetworkResource(client, state, &state.ManagedResources.Subnet, resourceTypeSubnet, subnetID); err != nil {
+			return err
 		}
-		if err := client.WaitForSubnetStatus(subnetID, "ACTIVE"); err != nil {
+		if err := waitForSubnetStatus(ctx, client, state, subnetID, "ACTIVE"); err != nil {
 			return fmt.Errorf("failed wating for subnet sttatus 'ACTIVE': %s", err)
 		}
 		state.SubnetID = subnetID
 	}
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
 
 	if state.HighwaySubnetID == "" && state.HighwaySubnetName != "" {
 		highwaySubnetID, err := client.FindSubnet(state.VpcID, state.HighwaySubnetName)
 		if err != nil {
 			return err
 		}
-		state.HighwaySubnetName = highwaySubnetID
+		if highwaySubnetID == "" {
+			subnet, err := createSubnet(client, state.VpcID, state.HighwaySubnetName, state.HighwaySubnetCIDR)
+			if err != nil {
+				return fmt.Errorf("failed to create highway subnet: %s", err)
+			}
+			state.ManagedResources.HighwaySubnet = true
+			highwaySubnetID = subnet.ID
+			if err := tagNetworkResource(client, state, resourceTypeSubnet, highwaySubnetID); err != nil {
+				return err
+			}
+		} else if err := adoptNetworkResource(client, state, &state.ManagedResources.HighwaySubnet, resourceTypeSubnet, highwaySubnetID); err != nil {
+			return err
+		}
+		if err := waitForSubnetStatus(ctx, client, state, highwaySubnetID, "ACTIVE"); err != nil {
+			return fmt.Errorf("failed waiting for highway subnet status 'ACTIVE': %s", err)
+		}
+		state.HighwaySubnetID = highwaySubnetID
+	}
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	if state.CreateNatGateway {
+		if err := createNatGateway(ctx, client, state); err != nil {
+			return err
+		}
 	}
 
-	if state.ClusterFloatingIP == "" {
-		eip, err := client.CreateEIP(&state.ClusterEIPOptions)
+	if err := setupSecurityGroups(client, state); err != nil {
+		return err
+	}
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	// cluster EIP is set only in cluster creation request, so it's not created for the adopted cluster
+	if state.ClusterFloatingIP == "" && state.ClusterID == "" {
+		name := clusterEipName(state)
+		existing, err := findNamedEip(client, name)
 		if err != nil {
 			return err
 		}
+		if existing != nil {
+			logrus.Infof("Using cluster EIP %s created by previous attempt", existing.PublicipAddress)
+			state.ClusterFloatingIP = existing.PublicipAddress
+		} else {
+			eip, err := createNamedEip(client, state.ClusterEIPOptions, name)
+			if err != nil {
+				return err
+			}
+			state.ClusterFloatingIP = eip.PublicAddress
+		}
 		state.ManagedResources.ClusterEip = true
-		state.ClusterFloatingIP = eip.PublicAddress
 	}
 
 	logrus.Debug("Setup network process finished")
 	return nil
 }
 
-func createCluster(client *services.Client, state *clusterState, opts *types.DriverOptions) error {
-	var nodeIPs []string
-	var nodeIDs []string
-	var clusterID string
-	nodeCount := opts.IntOptions["nodeCount"]
-	version := opts.StringOptions["clusterVersion"]
-
-	cluster, err := client.CreateCluster(&services.CreateClusterOpts{
-		Name:            state.ClusterName,
-		Description:     state.Description,
-		ClusterType:     state.ClusterType,
-		ClusterVersion:  version,
-		FlavorID:        state.ClusterFlavor,
-		VpcID:           state.VpcID,
-		SubnetID:        state.SubnetID,
-		HighwaySubnetID: state.HighwaySubnetID,
-		ContainerNetwork: clusters.ContainerNetworkSpec{
-			Mode: state.ContainerNetworkMode,
-			Cidr: state.ContainerNetworkCidr,
-		},
-		AuthenticationMode: state.AuthMode,
-		BillingMode:        state.ClusterBillingMode,
-		FloatingIP:         state.ClusterFloatingIP,
+func createCluster(ctx context.Context, client *services.Client, state *clusterState) error {
+	// cluster is already known when it's adopted from previous Create attempt
+	adopted := state.ClusterID != ""
+	err := runPhase(ctx, "cluster", state.Timeouts.Cluster, func(ctx context.Context) error {
+		if !adopted {
+			if err := checkContext(ctx); err != nil {
+				return err
+			}
+			if err := requestCluster(client, state); err != nil {
+				return err
+			}
+		}
+		logrus.Infof("Waiting for cluster %s to become available", state.ClusterID)
+		return waitForClusterAvailable(ctx, client, state)
 	})
 	if err != nil {
 		return err
 	}
-	clusterID = cluster.Metadata.Id
-	state.ClusterID = clusterID
-	state.NodeConfig.ClusterID = clusterID
 
-	nodeIDs, err = client.CreateNodes(&state.NodeConfig, int(nodeCount))
-	if err != nil {
-		return fmt.Errorf("failed to create nodes: %s", err)
+	return runPhase(ctx, "nodes", state.Timeouts.Nodes, func(ctx context.Context) error {
+		if adopted {
+			if err := adoptNodePools(client, state); err != nil {
+				return err
+			}
+		}
+		state.ManagedResources.Nodes = true
+		for i := range state.NodePools {
+			err := createNodePool(ctx, client, state, &state.NodePools[i])
+			// EIPs of nodes are recorded after each pool, so they are released on rollback even if the pool failed
+			if syncErr := syncNodeEips(client, state); syncErr != nil {
+				err = errors.Join(err, syncErr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// clusterCreateRequest returns cluster creation request body for the cluster state
+func clusterCreateRequest(state *clusterState) clusterCreateOpts {
+	extendParam := map[string]string{}
+	if state.ClusterFloatingIP != "" {
+		extendParam["clusterExternalIP"] = state.ClusterFloatingIP
+	}
+	if len(state.MasterAZs) == 1 {
+		extendParam["clusterAZ"] = state.MasterAZs[0]
+	} else if len(state.MasterAZs) > 1 {
+		extendParam["clusterAZ"] = multiAZ
+	}
+	if state.ClusterBillingMode == billingModePrepaid {
+		setClusterPeriod(extendParam, &state.Period)
+	}
+	var masters []masterSpec
+	for _, az := range state.MasterAZs {
+		masters = append(masters, masterSpec{AvailabilityZone: az})
+	}
+	containerNetwork := clusters.ContainerNetworkSpec{Mode: state.ContainerNetworkMode}
+	if state.ContainerNetworkMode != containerNetworkModeENI {
+		containerNetwork.Cidr = state.ContainerNetworkCidr
+	}
+	return clusterCreateOpts{
+		Kind:       "Cluster",
+		ApiVersion: "v3",
+		Metadata: clusters.CreateMetaData{
+			Name:   state.ClusterName,
+			Labels: cceClusterLabels(state.ClusterLabels, state.CreationToken),
+		},
+		Spec: clusterSpec{
+			Spec: clusters.Spec{
+				Type:        state.ClusterType,
+				Flavor:      state.ClusterFlavor,
+				Version:     state.ClusterVersion,
+				Description: state.Description,
+				HostNetwork: clusters.HostNetworkSpec{
+					VpcId:         state.VpcID,
+					SubnetId:      state.SubnetID,
+					HighwaySubnet: state.HighwaySubnetID,
+				},
+				ContainerNetwork: containerNetwork,
+				Authentication: clusters.AuthenticationSpec{
+					Mode:                state.AuthMode,
+					AuthenticatingProxy: map[string]string{},
+				},
+				BillingMode: state.ClusterBillingMode,
+				ExtendParam: extendParam,
+			},
+			Category:   clusterCategory(state),
+			EniNetwork: eniNetwork(state),
+			Masters:    masters,
+		},
 	}
-	state.NodeIDs = nodeIDs
-	nodesStatus, err := client.GetNodesStatus(clusterID, nodeIDs)
+}
+
+// requestCluster sends cluster creation request, the cluster is marked as managed right after it's requested
+func requestCluster(client *services.Client, state *clusterState) error {
+	cluster, err := clusters.Create(client.CCE, clusterCreateRequest(state)).Extract()
 	if err != nil {
 		return err
 	}
-	nodeIPs = make([]string, len(nodeIDs))
-	for i, nodeStatus := range nodesStatus {
-		nodeIPs[i] = nodeStatus.PrivateIP
-	}
+	state.ClusterID = cluster.Metadata.Id
+	state.ClusterJobID = cluster.Status.JobID
+	state.NodeConfig.ClusterID = state.ClusterID
+	state.ManagedResources.Cluster = true
 	return nil
 }
 
@@ -585,43 +1026,104 @@ func getClient(state *clusterState) (client *services.Client, err error) {
 	return client, nil
 }
 
-func cleanupManagedResources(client *services.Client, state *clusterState) error {
-	logrus.Debug("Cleanup process started")
-	resources := state.ManagedResources
+// cleanupManagedResources deletes resources created by the driver. Nodes and cluster are deleted first,
+// as network resources can't be removed while they are in use. Other failed steps don't stop the cleanup,
+// their errors are returned together
+func cleanupManagedResources(ctx context.Context, client *services.Client, state *clusterState) error {
+	logrus.Info("Cleanup process started")
+	resources := &state.ManagedResources
 
 	if resources.Nodes {
+		logrus.Infof("Deleting nodes of cluster %s", state.ClusterID)
+		if err := deleteClusterNodes(ctx, client, state); err != nil {
+			return fmt.Errorf("failed to delete nodes of cluster %s: %w", state.ClusterID, err)
+		}
 		resources.Nodes = false
 	}
 	if resources.Cluster {
+		logrus.Infof("Deleting cluster %s", state.ClusterID)
+		if err := deleteCluster(ctx, client, state); err != nil {
+			return fmt.Errorf("failed to delete cluster %s: %w", state.ClusterID, err)
+		}
 		resources.Cluster = false
 	}
-	if resources.ClusterEip {
-		if err := client.DeleteFloatingIP(state.ClusterFloatingIP); err != nil {
-			return err
+
+	var errs []error
+	cleanup := func(managed *bool, name string, remove func() error) {
+		if !*managed {
+			return
 		}
-		resources.ClusterEip = false
-	}
-	if resources.Subnet {
-		if err := client.DeleteSubnet(state.VpcID, state.SubnetID); err != nil {
-			return err
+		logrus.Infof("Deleting %s", name)
+		if err := remove(); err != nil {
+			logrus.WithError(err).Errorf("Failed to delete %s", name)
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", name, err))
+			return
 		}
-		err := client.WaitForSubnetStatus(state.SubnetID, "")
-		if err, ok := err.(golangsdk.ErrDefault404); !ok {
-			return err
+		*managed = false
+	}
+	if len(resources.NodeEips) > 0 {
+		logrus.Infof("Releasing node EIPs %s", strings.Join(resources.NodeEips, ", "))
+		if err := releaseNodeEips(client, state); err != nil {
+			logrus.WithError(err).Error("Failed to release node EIPs")
+			errs = append(errs, err)
 		}
-		resources.Subnet = false
 	}
-	if resources.Vpc {
-		if err := client.DeleteVPC(state.VpcID); err != nil {
-			return err
+	cleanup(&resources.ClusterEip, "cluster EIP "+state.ClusterFloatingIP, func() error {
+		return releaseEip(client, state.ClusterFloatingIP)
+	})
+	if resources.SnatRule || resources.NatGateway || resources.NatEip {
+		logrus.Infof("Deleting NAT gateway %s", state.NatGatewayID)
+		if err := cleanupNatGateway(ctx, client, state); err != nil {
+			logrus.WithError(err).Error("Failed to delete NAT gateway")
+			errs = append(errs, err)
 		}
-		resources.Vpc = false
 	}
-	logrus.Debug("Cleanup process finished")
+	cleanup(&resources.SecurityGroup, "security group "+state.SecurityGroupID, func() error {
+		return deleteSecurityGroup(ctx, client, state, state.SecurityGroupID)
+	})
+	cleanup(&resources.HighwaySubnet, "highway subnet "+state.HighwaySubnetID, func() error {
+		return deleteSubnet(ctx, client, state, state.VpcID, state.HighwaySubnetID)
+	})
+	cleanup(&resources.Subnet, "subnet "+state.SubnetID, func() error {
+		return deleteSubnet(ctx, client, state, state.VpcID, state.SubnetID)
+	})
+	cleanup(&resources.Vpc, "VPC "+state.VpcID, func() error {
+		return deleteVPC(ctx, client, state, state.VpcID)
+	})
+	logrus.Info("Cleanup process finished")
+	return errors.Join(errs...)
+}
+
+// validateState checks cluster options before anything is provisioned
+func validateState(catalog *clusterCatalog, state *clusterState) error {
+	if err := validateClusterSpec(catalog, state); err != nil {
+		return err
+	}
+	if err := validateContainerNetwork(state); err != nil {
+		return err
+	}
+	if err := validateBareMetal(state); err != nil {
+		return err
+	}
+	if err := validateBilling(state); err != nil {
+		return err
+	}
+	if err := validateVolumes(state); err != nil {
+		return err
+	}
+	if err := validateNodeEip(state); err != nil {
+		return err
+	}
+	if err := validateNatGateway(state); err != nil {
+		return err
+	}
+	if err := validateSecurityGroups(state); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (d *CCEDriver) Create(_ context.Context, opts *types.DriverOptions, info *types.ClusterInfo) (*types.ClusterInfo, error) {
+func (d *CCEDriver) Create(ctx context.Context, opts *types.DriverOptions, info *types.ClusterInfo) (*types.ClusterInfo, error) {
 	logrus.Info("Start creating cluster")
 	if info == nil {
 		logrus.Debug("Info is nil, initialize info")
@@ -632,6 +1134,10 @@ func (d *CCEDriver) Create(_ context.Context, opts *types.DriverOptions, info *t
 	if err != nil {
 		return nil, fmt.Errorf("error setting opts to cluster state: %s", err)
 	}
+	d.resolveTimeouts(state)
+	if state.CreationToken, err = creationToken(info); err != nil {
+		return nil, err
+	}
 	client, err := getClient(state)
 	if err != nil {
 		return nil, err
@@ -639,50 +1145,121 @@ func (d *CCEDriver) Create(_ context.Context, opts *types.DriverOptions, info *t
 	token, _ := client.Token() // error can only during auth
 	info.ServiceAccountToken = token
 
+	if err := validateState(getCatalog(client, state.Region), state); err != nil {
+		return nil, err
+	}
+	return createWithRollback(ctx, client, state, info)
+}
+
+// createWithRollback creates the cluster with its network and nodes, resources created before a failure are removed
+func createWithRollback(ctx context.Context, client *services.Client, state *clusterState, info *types.ClusterInfo) (result *types.ClusterInfo, err error) {
 	state.ManagedResources = managedResources{}
+	// `err` is the named result, so rollback runs for every failure returned below
 	defer func() {
-		if err != nil {
-			logrus.WithError(err).Info("creation failed")
-			logrus.WithError(cleanupManagedResources(client, state))
+		if err == nil {
+			return
+		}
+		logrus.WithError(err).WithField("progress", progressSummary(state)).
+			Error("Cluster creation failed, removing created resources")
+		// cancelled Create still has to remove what it has created, so rollback doesn't use its context
+		if cleanupErr := cleanupManagedResources(context.Background(), client, state); cleanupErr != nil {
+			logrus.WithError(cleanupErr).Error("Failed to remove created resources")
+			err = errors.Join(err, fmt.Errorf("cleanup failed: %w", cleanupErr))
 		}
+		// Rancher saves info returned with the error, so progress of failed jobs and resources
+		// left after the cleanup are visible
+		result, _ = stateToInfo(state, info)
 	}()
 
-	if err := setupNetwork(client, state); err != nil {
-		return nil, fmt.Errorf("failed to setup network: %s", err)
+	// Create can be retried by Rancher, the cluster left by previous attempt is used then
+	existing, err := findCluster(client, state.ClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for existing cluster: %s", err)
+	}
+	if existing != nil {
+		if err := adoptCluster(state, existing); err != nil {
+			return nil, err
+		}
+		if err := adoptNetwork(client, state); err != nil {
+			return nil, err
+		}
+	}
+	// network resources left by previous attempt are found and reused by setupNetwork
+	err = runPhase(ctx, "network", state.Timeouts.Network, func(ctx context.Context) error {
+		return setupNetwork(ctx, client, state)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup network: %w", err)
+	}
+	if existing == nil {
+		if state.ContainerNetworkMode == containerNetworkModeENI {
+			if err := validateEniSubnets(client, state); err != nil {
+				return nil, err
+			}
+		}
+		if state.LoadBalancerID != "" {
+			if err := validateLoadBalancer(client, state); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	if err := createCluster(client, state, opts); err != nil {
-		return nil, fmt.Errorf("failed to create cluster: %s", err)
+	if err := createCluster(ctx, client, state); err != nil {
+		return nil, fmt.Errorf("failed to create cluster: %w", err)
 	}
-	info.NodeCount = opts.IntOptions["nodeCount"]
-	info.Version = opts.StringOptions["clusterVersion"]
+	info.NodeCount = totalNodeCount(state.NodePools)
+	info.Version = state.ClusterVersion
 
 	logrus.Info("Cluster creation finished")
 	return stateToInfo(state, info)
 }
 
 // Update changes existing cluster. `clusterInfo` represents current state, `updateOpts` are newly applied flags
-func (d *CCEDriver) Update(_ context.Context, info *types.ClusterInfo, updateOpts *types.DriverOptions) (*types.ClusterInfo, error) {
+func (d *CCEDriver) Update(ctx context.Context, info *types.ClusterInfo, updateOpts *types.DriverOptions) (*types.ClusterInfo, error) {
 	var err error
 	logrus.Info("Starting update")
 	state, err := infoToState(info)
 	if err != nil {
 		return nil, err
 	}
+	resetProgress(state)
 
 	newState, err := optsToState(updateOpts)
 	if err != nil {
 		return nil, err
 	}
 	newState.ClusterID = state.ClusterID
+	// timeouts which are not set in update options are kept
+	state.TimeoutOptions = newState.TimeoutOptions.withDefaults(state.TimeoutOptions)
+	d.resolveTimeouts(state)
+	if newState.RollingUpdate.isSet() {
+		state.RollingUpdate = newState.RollingUpdate
+	}
+	if !state.RollingUpdate.isSet() {
+		state.RollingUpdate = defaultRollingUpdate()
+	}
 
-	newCount := updateOpts.IntOptions["nodeCount"]
-	if newCount != info.NodeCount {
-		tmpState, err := d.resizeCluster(info, newCount)
+	previousSettings := currentNodeSettings(state)
+	_, strSliceOpt, _, _ := getters(updateOpts)
+	explicitPools := len(strSliceOpt("node-pools", "nodePools")) > 0
+	if explicitPools {
+		client, err := getClient(state)
 		if err != nil {
 			return nil, err
 		}
-		state.NodeIDs = tmpState.NodeIDs
+		if err := resizeNodePools(ctx, client, state, newState.NodePools); err != nil {
+			return nil, err
+		}
+		info.NodeCount = totalNodeCount(state.NodePools)
+	} else if newCount := totalNodeCount(newState.NodePools); newCount != info.NodeCount {
+		tmpState, err := d.resizeCluster(ctx, info, newCount)
+		if err != nil {
+			return nil, err
+		}
+		state.NodePools = tmpState.NodePools
+		state.NodeJobIDs = tmpState.NodeJobIDs
+		state.Progress = tmpState.Progress
+		state.ManagedResources.NodeEips = tmpState.ManagedResources.NodeEips
 	}
 
 	if newState.Description != state.Description {
@@ -697,15 +1274,88 @@ func (d *CCEDriver) Update(_ context.Context, info *types.ClusterInfo, updateOpt
 		state.Description = newState.Description
 	}
 
+	if !labelsEqual(newState.ClusterLabels, state.ClusterLabels) {
+		logrus.Info("Updating cluster labels")
+		client, err := getClient(state)
+		if err != nil {
+			return nil, err
+		}
+		if err := updateClusterLabels(client, state, newState.ClusterLabels); err != nil {
+			return nil, fmt.Errorf("failed to update cluster labels: %s", err)
+		}
+		state.ClusterLabels = newState.ClusterLabels
+	}
+
+	state.NodeLabels = newState.NodeLabels
+	state.NodeTaints = newState.NodeTaints
+	for i := range state.NodePools {
+		pool := &state.NodePools[i]
+		if update := findNodePool(newState.NodePools, pool.Name); explicitPools && update != nil {
+			pool.Labels, pool.Taints = update.Labels, update.Taints
+		}
+		settings, ok := previousSettings[pool.Name]
+		if !ok {
+			// pool is created by this update with current settings
+			continue
+		}
+		if labelsEqual(settings.Labels, nodeLabels(state, pool)) && taintsEqual(settings.Taints, nodeTaintSpecs(state, pool)) {
+			continue
+		}
+		client, err := getClient(state)
+		if err != nil {
+			return nil, err
+		}
+		clientSet, err := getClientSet(info)
+		if err != nil {
+			return nil, fmt.Errorf("error creating clientset: %v", err)
+		}
+		if err := reconcilePoolNodes(ctx, client, clientSet, state, pool, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	desiredPools, replaced, err := planNodeReplacement(state, newState)
+	if err != nil {
+		return nil, err
+	}
+	if len(replaced) > 0 {
+		logrus.Info("Replacing nodes with changed configuration")
+		if newState.NodeConfig.Os != "" {
+			state.NodeConfig.Os = newState.NodeConfig.Os
+		}
+		client, err := getClient(state)
+		if err != nil {
+			return nil, err
+		}
+		clientSet, err := getClientSet(info)
+		if err != nil {
+			return nil, fmt.Errorf("error creating clientset: %v", err)
+		}
+		if err := replaceNodes(ctx, client, clientSet, state, desiredPools, replaced); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.LoadBalancerID != "" {
+		clientSet, err := getClientSet(info)
+		if err != nil {
+			return nil, fmt.Errorf("error creating clientset: %v", err)
+		}
+		if err := assignLoadBalancer(ctx, clientSet, state.LoadBalancerID); err != nil {
+			return nil, err
+		}
+	}
+
 	logrus.Info("Update cluster success")
 	return stateToInfo(state, info)
 }
 
-func (d *CCEDriver) PostCheck(_ context.Context, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
+func (d *CCEDriver) PostCheck(ctx context.Context, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
 	state, err := infoToState(clusterInfo)
 	if err != nil {
 		return nil, err
 	}
+	d.resolveTimeouts(state)
 	client, err := getClient(state)
 	if err != nil {
 		return nil, err
@@ -747,25 +1397,17 @@ func (d *CCEDriver) PostCheck(_ context.Context, clusterInfo *types.ClusterInfo)
 		return nil, fmt.Errorf("error creating clientset: %v", err)
 	}
 
-	failureCount := 0
-
-	for {
-		clusterInfo.ServiceAccountToken, err = generateServiceAccountToken(clientSet)
+	err = runPhase(ctx, "post-check", state.Timeouts.PostCheck, func(ctx context.Context) error {
+		clusterInfo.ServiceAccountToken, err = retryServiceAccountToken(ctx, clientSet, state.Timeouts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if err == nil {
-			logrus.Info("service account token generated successfully")
-			break
-		} else {
-			logrus.WithError(err).Warnf("error creating service account")
-			if failureCount < retries {
-				logrus.Infof("service account token generation failed, retries left: %v", retries-failureCount)
-				failureCount++
-
-				time.Sleep(pollInterval * time.Second)
-			} else {
-				logrus.Error("retries exceeded, failing post-check")
-				return nil, err
-			}
+	if state.LoadBalancerID != "" {
+		if err := assignLoadBalancer(ctx, clientSet, state.LoadBalancerID); err != nil {
+			return nil, err
 		}
 	}
 
@@ -775,23 +1417,35 @@ func (d *CCEDriver) PostCheck(_ context.Context, clusterInfo *types.ClusterInfo)
 	return clusterInfo, nil
 }
 
-func (d *CCEDriver) Remove(_ context.Context, clusterInfo *types.ClusterInfo) error {
+func (d *CCEDriver) Remove(ctx context.Context, clusterInfo *types.ClusterInfo) error {
 	logrus.Info("Get state from info")
 	state, err := infoToState(clusterInfo)
 	if err != nil {
 		return err
 	}
+	d.resolveTimeouts(state)
 	client, err := getClient(state)
 	if err != nil {
 		return err
 	}
-	if err := client.DeleteNodes(state.ClusterID, state.NodeIDs); err != nil {
-		return err
+	for i, pool := range state.NodePools {
+		if err := deleteNodePool(ctx, client, state, &state.NodePools[i]); err != nil {
+			if state.NodeConfig.ChargingMode == chargingModePrepaid {
+				return prepaidDeleteError("nodes of pool "+pool.Name, err)
+			}
+			return fmt.Errorf("failed to delete node pool %s: %s", pool.Name, err)
+		}
 	}
-	if err := client.DeleteCluster(state.ClusterID); err != nil {
+	state.ManagedResources.Nodes = false
+	// network resources can't be removed while prepaid cluster is waiting for unsubscription
+	if err := deleteCluster(ctx, client, state); err != nil {
+		if state.ClusterBillingMode == billingModePrepaid {
+			return prepaidDeleteError("cluster", err)
+		}
 		return err
 	}
-	if err := cleanupManagedResources(client, state); err != nil {
+	state.ManagedResources.Cluster = false
+	if err := cleanupManagedResources(ctx, client, state); err != nil {
 		return err
 	}
 	return nil
@@ -801,56 +1455,225 @@ func (d *CCEDriver) GetVersion(_ context.Context, info *types.ClusterInfo) (*typ
 	return &types.KubernetesVersion{Version: info.Version}, nil
 }
 
-func (d *CCEDriver) SetVersion(context.Context, *types.ClusterInfo, *types.KubernetesVersion) error {
-	return fmt.Errorf("setting version is not implemented")
+// normalizeVersion returns k8s version in the form used by CCE, e.g. `v1.25`
+func normalizeVersion(version string) string {
+	if version == "" || strings.HasPrefix(version, "v") {
+		return version
+	}
+	return "v" + version
+}
+
+// majorMinorVersion returns major and minor parts of version in `v1.25`, `v1.25.3` or `v1.25.3-r0` format
+func majorMinorVersion(version string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(normalizeVersion(version), "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid cluster version %s", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid cluster version %s", version)
+	}
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid cluster version %s", version)
+	}
+	return major, minor, nil
+}
+
+// compareVersions compares major and minor parts of versions, result is -1, 0 or 1 like in strings.Compare
+func compareVersions(a, b string) (int, error) {
+	aMajor, aMinor, err := majorMinorVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bMajor, bMinor, err := majorMinorVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	if aMajor != bMajor {
+		return compareInts(aMajor, bMajor), nil
+	}
+	return compareInts(aMinor, bMinor), nil
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SetVersion upgrades cluster control plane and then worker nodes to the given version
+func (d *CCEDriver) SetVersion(ctx context.Context, info *types.ClusterInfo, version *types.KubernetesVersion) error {
+	state, err := infoToState(info)
+	if err != nil {
+		return err
+	}
+	resetProgress(state)
+	d.resolveTimeouts(state)
+	client, err := getClient(state)
+	if err != nil {
+		return err
+	}
+	clientSet, err := getClientSet(info)
+	if err != nil {
+		return fmt.Errorf("error creating clientset: %v", err)
+	}
+	target := normalizeVersion(version.Version)
+	catalog := getCatalog(client, state.Region)
+	if !catalog.supportsVersion(target) {
+		return fmt.Errorf("unsupported cluster version %s", target)
+	}
+	if err := upgradeCluster(ctx, client, clientSet, state, catalog, target); err != nil {
+		return err
+	}
+	info.Version = target
+	_, err = stateToInfo(state, info)
+	return err
+}
+
+// upgradeCluster upgrades the cluster to the target version and waits until the upgrade of masters and nodes
+// is finished, `state.ClusterVersion` is updated. CCE upgrades clusters only to the next version of the catalog,
+// so downgrades and upgrades skipping versions are rejected before the upgrade is started
+func upgradeCluster(ctx context.Context, client *services.Client, clientSet kubernetes.Interface, state *clusterState, catalog *clusterCatalog, target string) error {
+	cluster, err := client.GetCluster(state.ClusterID)
+	if err != nil {
+		return err
+	}
+	current := cluster.Spec.Version
+	cmp, err := compareVersions(target, current)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return fmt.Errorf("cluster version %s can't be downgraded to %s", current, target)
+	}
+	if cmp == 0 {
+		logrus.Infof("Cluster is already running version %s", current)
+		state.ClusterVersion = target
+		return nil
+	}
+	next := catalog.nextVersion(current)
+	if cmp, _ := compareVersions(target, next); next == "" || cmp != 0 {
+		return fmt.Errorf("cluster version %s can be upgraded only to the next version %s, not to %s", current, next, target)
+	}
+
+	logrus.Infof("Start upgrading cluster from %s to %s", current, target)
+	taskID, err := startClusterUpgrade(client, state.ClusterID, target)
+	if err != nil {
+		return fmt.Errorf("failed to start cluster upgrade: %s", err)
+	}
+	if err := waitForUpgradeTask(ctx, client, state, taskID); err != nil {
+		return fmt.Errorf("failed to upgrade cluster: %s", err)
+	}
+	if err := waitForClusterNodesActive(ctx, client, state); err != nil {
+		return fmt.Errorf("failed waiting for nodes to be upgraded: %s", err)
+	}
+	if err := waitForKubeNodesVersion(ctx, clientSet, state, target); err != nil {
+		return fmt.Errorf("failed waiting for nodes to be upgraded: %s", err)
+	}
+	state.ClusterVersion = target
+
+	logrus.Infof("Cluster upgrade to %s finished", target)
+	return nil
+}
+
+// waitForKubeNodesVersion waits until kubelets of all cluster nodes report the version
+func waitForKubeNodesVersion(ctx context.Context, clientSet kubernetes.Interface, state *clusterState, version string) error {
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		nodeList, err := clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return true, fmt.Errorf("failed to list nodes: %s", err)
+		}
+		for _, node := range nodeList.Items {
+			kubeletVersion := node.Status.NodeInfo.KubeletVersion
+			if cmp, err := compareVersions(kubeletVersion, version); err != nil || cmp != 0 {
+				logrus.Debugf("Node %s is running version %s", node.Name, kubeletVersion)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
 }
 
 func (d *CCEDriver) GetClusterSize(_ context.Context, info *types.ClusterInfo) (*types.NodeCount, error) {
 	return &types.NodeCount{Count: info.NodeCount}, nil
 }
 
-// resizeCluster update nodes, creating or removing nodes. `info.NodeCount` is updated inside
-func (d *CCEDriver) resizeCluster(info *types.ClusterInfo, newSize int64) (*clusterState, error) {
+// resizeCluster scales node pools to have `newSize` nodes in total. New nodes are added to the first pool,
+// nodes are removed starting from the first pool. `info` is updated inside
+func (d *CCEDriver) resizeCluster(ctx context.Context, info *types.ClusterInfo, newSize int64) (*clusterState, error) {
 	state, err := infoToState(info)
 	if err != nil {
 		return nil, err
 	}
+	d.resolveTimeouts(state)
+	if len(state.NodePools) == 0 {
+		return nil, fmt.Errorf("cluster has no node pools, resize is not supported")
+	}
+	resetProgress(state)
 	client, err := getClient(state)
 	if err != nil {
 		return nil, err
 	}
-	delta := newSize - info.NodeCount
-	logrus.Info("Start setting cluster size")
-	if delta == 0 {
-		return state, nil
+	// node pools can be scaled outside of rancher, so actual sizes are used
+	for i, pool := range state.NodePools {
+		if pool.isNodeGroup() {
+			state.NodePools[i].Count = len(pool.NodeIDs)
+			continue
+		}
+		current, err := nodepools.Get(client.CCE, state.ClusterID, pool.ID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node pool %s: %s", pool.Name, err)
+		}
+		state.NodePools[i].Count = current.Spec.InitialNodeCount
 	}
+	delta := newSize - totalNodeCount(state.NodePools)
+	logrus.Info("Start setting cluster size")
 	if delta > 0 {
 		logrus.Infof("Will create %d new nodes", delta)
-		state.NodeConfig.ClusterID = state.ClusterID
-		newNodes, err := client.CreateNodes(&state.NodeConfig, int(delta))
-		if err != nil {
+		pool := &state.NodePools[0]
+		if err := scaleNodePool(ctx, client, state, pool, pool.Count+int(delta)); err != nil {
 			return nil, err
 		}
-		state.NodeIDs = append(state.NodeIDs, newNodes...)
-	} else {
-		logrus.Infof("Will remove %d nodes", -delta)
-		nodesToDelete := state.NodeIDs[newSize:]
-		err := client.DeleteNodes(state.ClusterID, nodesToDelete)
-		if err != nil {
+	}
+	for i := range state.NodePools {
+		if delta >= 0 {
+			break
+		}
+		pool := &state.NodePools[i]
+		remove := int(-delta)
+		if remove > pool.Count {
+			remove = pool.Count
+		}
+		if remove == 0 {
+			continue
+		}
+		logrus.Infof("Will remove %d nodes from node pool %s", remove, pool.Name)
+		if err := scaleNodePool(ctx, client, state, pool, pool.Count-remove); err != nil {
 			return nil, err
 		}
-		state.NodeIDs = state.NodeIDs[:newSize]
+		delta += int64(remove)
 	}
-	info.NodeCount = int64(len(state.NodeIDs))
+	if err := syncNodeEips(client, state); err != nil {
+		return nil, err
+	}
+	info.NodeCount = totalNodeCount(state.NodePools)
 	if info.NodeCount != newSize {
-		return nil, fmt.Errorf("resize failed: expected %d items, got %d in %v", newSize, len(state.NodeIDs), state.NodeIDs)
+		return nil, fmt.Errorf("resize failed: expected %d nodes, got %d", newSize, info.NodeCount)
 	}
 	logrus.Infof("Setting cluster size to %v finished", newSize)
+	if _, err := stateToInfo(state, info); err != nil {
+		return nil, err
+	}
 	return state, nil
 }
 
-func (d *CCEDriver) SetClusterSize(_ context.Context, info *types.ClusterInfo, count *types.NodeCount) error {
-	_, err := d.resizeCluster(info, count.Count)
+func (d *CCEDriver) SetClusterSize(ctx context.Context, info *types.ClusterInfo, count *types.NodeCount) error {
+	_, err := d.resizeCluster(ctx, info, count.Count)
 	if err != nil {
 		return err
 	}
@@ -893,37 +1716,99 @@ func (d *CCEDriver) RemoveLegacyServiceAccount(_ context.Context, info *types.Cl
 	return util.DeleteLegacyServiceAccountAndRoleBinding(clientSet)
 }
 
-var noETCDBackup = fmt.Errorf("ETCD backup operations are not implemented")
-
-func (d *CCEDriver) ETCDSave(context.Context, *types.ClusterInfo, *types.DriverOptions, string) error {
-	return noETCDBackup
+// ETCDSave saves all cluster resources as a snapshot to OBS bucket, CCE doesn't provide access to etcd itself
+func (d *CCEDriver) ETCDSave(ctx context.Context, info *types.ClusterInfo, opts *types.DriverOptions, snapshotName string) error {
+	state, err := infoToState(info)
+	if err != nil {
+		return err
+	}
+	backup, err := getBackupOpts(state, opts)
+	if err != nil {
+		return err
+	}
+	clientSet, err := getClientSet(info)
+	if err != nil {
+		return fmt.Errorf("error creating clientset: %v", err)
+	}
+	return saveSnapshot(ctx, clientSet, backup, state.ClusterID, snapshotName)
 }
 
-func (d *CCEDriver) ETCDRestore(context.Context, *types.ClusterInfo, *types.DriverOptions, string) (*types.ClusterInfo, error) {
-	return nil, noETCDBackup
+// ETCDRestore applies cluster resources from the snapshot saved by ETCDSave
+func (d *CCEDriver) ETCDRestore(ctx context.Context, info *types.ClusterInfo, opts *types.DriverOptions, snapshotName string) (*types.ClusterInfo, error) {
+	state, err := infoToState(info)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := getBackupOpts(state, opts)
+	if err != nil {
+		return nil, err
+	}
+	clientSet, err := getClientSet(info)
+	if err != nil {
+		return nil, fmt.Errorf("error creating clientset: %v", err)
+	}
+	if err := restoreSnapshot(ctx, clientSet, backup, state.ClusterID, snapshotName); err != nil {
+		return nil, err
+	}
+	return stateToInfo(state, info)
 }
 
-func (d *CCEDriver) ETCDRemoveSnapshot(context.Context, *types.ClusterInfo, *types.DriverOptions, string) error {
-	return noETCDBackup
+// ETCDRemoveSnapshot removes snapshot saved by ETCDSave from OBS bucket, already removed snapshot is not an error
+func (d *CCEDriver) ETCDRemoveSnapshot(_ context.Context, info *types.ClusterInfo, opts *types.DriverOptions, snapshotName string) error {
+	state, err := infoToState(info)
+	if err != nil {
+		return err
+	}
+	backup, err := getBackupOpts(state, opts)
+	if err != nil {
+		return err
+	}
+	key := snapshotKey(state.ClusterID, snapshotName)
+	if err := deleteSnapshot(backup, key); err != nil {
+		return fmt.Errorf("failed to remove snapshot %s/%s: %s", backup.Bucket, key, err)
+	}
+	logrus.Infof("Snapshot %s/%s removed", backup.Bucket, key)
+	return nil
 }
 
-func (d *CCEDriver) GetK8SCapabilities(context.Context, *types.DriverOptions) (*types.K8SCapabilities, error) {
-	return &types.K8SCapabilities{
+func (d *CCEDriver) GetK8SCapabilities(_ context.Context, opts *types.DriverOptions) (*types.K8SCapabilities, error) {
+	capabilities := &types.K8SCapabilities{
 		L4LoadBalancer: &types.LoadBalancerCapabilities{
 			Enabled: false,
 		},
-		NodePoolScalingSupported: false,
-	}, nil
+		NodePoolScalingSupported: true,
+	}
+	if opts == nil {
+		return capabilities, nil
+	}
+	strOpt, _, _, _ := getters(opts)
+	// LoadBalancer services are served only by the configured ELB
+	if strOpt("load-balancer", "loadBalancer") != "" {
+		capabilities.L4LoadBalancer = &types.LoadBalancerCapabilities{
+			Enabled:              true,
+			Provider:             elbProvider,
+			ProtocolsSupported:   elbProtocols,
+			HealthCheckSupported: true,
+		}
+	}
+	return capabilities, nil
 }
 
 func NewDriver() types.Driver {
+	return NewDriverWithTimeouts(DefaultTimeouts())
+}
+
+// NewDriverWithTimeouts creates driver using `timeouts` for clusters which don't set them in options
+func NewDriverWithTimeouts(timeouts Timeouts) types.Driver {
 	driver := &CCEDriver{
 		driverCapabilities: types.Capabilities{
 			Capabilities: make(map[int64]bool),
 		},
+		timeouts: timeouts.withDefaults(DefaultTimeouts()),
 	}
 
 	driver.driverCapabilities.AddCapability(types.GetVersionCapability)
+	driver.driverCapabilities.AddCapability(types.SetVersionCapability)
 	driver.driverCapabilities.AddCapability(types.GetClusterSizeCapability)
 	driver.driverCapabilities.AddCapability(types.SetClusterSizeCapability)
 
@@ -0,0 +1,192 @@
+package opentelekomcloud
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v1/bandwidths"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v1/eips"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bandwidthSharePer   = "PER"
+	bandwidthShareWhole = "WHOLE"
+
+	maxBandwidthSize = 1000
+
+	defaultEipType       = "5_bgp"
+	defaultBandwidthSize = 100
+)
+
+// validateNodeEip checks bandwidth settings of node EIPs
+func validateNodeEip(state *clusterState) error {
+	config := state.NodeConfig
+	if config.EipCount == 0 {
+		return nil
+	}
+	switch config.EipOpts.BandwidthType {
+	case bandwidthSharePer:
+		size := config.EipOpts.BandwidthSize
+		if size < 1 || size > maxBandwidthSize {
+			return fmt.Errorf("node-eip-bandwidth-size should be between 1 and %d MBit, got %d", maxBandwidthSize, size)
+		}
+	case bandwidthShareWhole:
+		if state.NodeBandwidthID == "" {
+			return fmt.Errorf("node-eip-bandwidth-id is required for %s node-eip-share-type", bandwidthShareWhole)
+		}
+	default:
+		return fmt.Errorf("node-eip-share-type should be %s or %s, got %s",
+			bandwidthSharePer, bandwidthShareWhole, config.EipOpts.BandwidthType)
+	}
+	return nil
+}
+
+// nodeEipSpec returns EIP spec of a single node, nil is returned if nodes have no EIP
+func nodeEipSpec(state *clusterState) *nodePublicIP {
+	config := state.NodeConfig
+	if config.EipCount == 0 {
+		return nil
+	}
+	bandwidth := nodeBandwidth{
+		BandwidthOpts: nodes.BandwidthOpts{ShareType: config.EipOpts.BandwidthType},
+	}
+	if bandwidth.ShareType == bandwidthShareWhole {
+		bandwidth.ID = state.NodeBandwidthID
+	} else {
+		bandwidth.Size = config.EipOpts.BandwidthSize
+	}
+	return &nodePublicIP{
+		Count: config.EipCount,
+		Eip: nodeEip{
+			IpType:    config.EipOpts.IPType,
+			Bandwidth: bandwidth,
+		},
+	}
+}
+
+// syncNodeEips records EIPs of cluster nodes as managed resources and releases EIPs of removed nodes,
+// EIPs are tracked by ID as the address of released EIP can be assigned to another one
+func syncNodeEips(client *services.Client, state *clusterState) error {
+	if state.NodeConfig.EipCount == 0 {
+		return nil
+	}
+	nodeList, err := nodes.List(client.CCE, state.ClusterID, nodes.ListOpts{})
+	if err != nil {
+		return fmt.Errorf("failed to list cluster nodes: %s", err)
+	}
+	eipList, err := eips.List(client.VPC, eips.ListOpts{})
+	if err != nil {
+		return fmt.Errorf("failed to list EIPs: %s", err)
+	}
+	eipIDs := map[string]string{}
+	for _, eip := range eipList {
+		eipIDs[eip.PublicAddress] = eip.ID
+	}
+	current := map[string]bool{}
+	var ids []string
+	for _, node := range nodeList {
+		id := eipIDs[node.Status.PublicIP]
+		if id != "" && !current[id] {
+			current[id] = true
+			ids = append(ids, id)
+		}
+	}
+	var errs []error
+	for _, id := range state.ManagedResources.NodeEips {
+		if current[id] {
+			continue
+		}
+		if err := releaseEipByID(client, id); err != nil {
+			// EIP is kept in managed resources to be released later
+			errs = append(errs, fmt.Errorf("failed to release node EIP %s: %s", id, err))
+			ids = append(ids, id)
+		}
+	}
+	state.ManagedResources.NodeEips = ids
+	return errors.Join(errs...)
+}
+
+// releaseNodeEips releases all node EIPs recorded in managed resources
+func releaseNodeEips(client *services.Client, state *clusterState) error {
+	var remaining []string
+	var errs []error
+	for _, id := range state.ManagedResources.NodeEips {
+		if err := releaseEipByID(client, id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release node EIP %s: %s", id, err))
+			remaining = append(remaining, id)
+		}
+	}
+	state.ManagedResources.NodeEips = remaining
+	return errors.Join(errs...)
+}
+
+// releaseEipByID releases EIP with given ID, already released EIP is not an error
+func releaseEipByID(client *services.Client, id string) error {
+	logrus.Infof("Releasing EIP %s", id)
+	if err := eips.Delete(client.VPC, id).Err; err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// releaseEip releases EIP with given address, already released EIP is not an error
+func releaseEip(client *services.Client, address string) error {
+	id, err := client.FindFloatingIP(address)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	logrus.Infof("Releasing EIP %s", address)
+	return floatingips.Delete(client.ComputeV2, id).Err
+}
+
+// clusterEipName and natEipName are bandwidth names of EIPs created by the driver,
+// EIPs left by previous Create attempt are found by these names
+func clusterEipName(state *clusterState) string {
+	return state.ClusterName + "-cluster-eip"
+}
+
+func natEipName(state *clusterState) string {
+	return state.ClusterName + "-nat-eip"
+}
+
+// createNamedEip allocates EIP with the bandwidth named `name`, unset options use the same defaults as client.CreateEIP
+func createNamedEip(client *services.Client, opts services.ElasticIPOpts, name string) (*eips.PublicIp, error) {
+	if opts.IPType == "" {
+		opts.IPType = defaultEipType
+	}
+	if opts.BandwidthSize == 0 {
+		opts.BandwidthSize = defaultBandwidthSize
+	}
+	if opts.BandwidthType == "" {
+		opts.BandwidthType = bandwidthSharePer
+	}
+	return eips.Apply(client.VPC, eips.ApplyOpts{
+		IP: eips.PublicIpOpts{Type: opts.IPType},
+		Bandwidth: eips.BandwidthOpts{
+			Name:      name,
+			Size:      opts.BandwidthSize,
+			ShareType: opts.BandwidthType,
+		},
+	}).Extract()
+}
+
+// findNamedEip returns EIP with the bandwidth named `name`, nil is returned if there is no such EIP
+func findNamedEip(client *services.Client, name string) (*bandwidths.PublicIpinfo, error) {
+	bandwidthList, err := bandwidths.List(client.VPC, bandwidths.ListOpts{}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bandwidths: %s", err)
+	}
+	for _, bandwidth := range bandwidthList {
+		if bandwidth.Name == name && len(bandwidth.PublicipInfo) > 0 {
+			return &bandwidth.PublicipInfo[0], nil
+		}
+	}
+	return nil, nil
+}
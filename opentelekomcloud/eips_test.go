@@ -0,0 +1,98 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNodeEip(t *testing.T) {
+	state := &clusterState{}
+	assert.NoError(t, validateNodeEip(state))
+
+	state.NodeConfig = services.CreateNodesOpts{
+		EipCount: 1,
+		EipOpts:  services.ElasticIPOpts{IPType: "5_bgp", BandwidthSize: 100, BandwidthType: bandwidthSharePer},
+	}
+	assert.NoError(t, validateNodeEip(state))
+	state.NodeConfig.EipOpts.BandwidthSize = 0
+	assert.Error(t, validateNodeEip(state))
+	state.NodeConfig.EipOpts.BandwidthType = bandwidthShareWhole
+	assert.Error(t, validateNodeEip(state), "bandwidth ID is required")
+	state.NodeBandwidthID = "bandwidth-id"
+	assert.NoError(t, validateNodeEip(state))
+	state.NodeConfig.EipOpts.BandwidthType = "SHARED"
+	assert.Error(t, validateNodeEip(state))
+}
+
+func TestNodeEipSpec(t *testing.T) {
+	state := &clusterState{}
+	assert.Nil(t, nodeEipSpec(state))
+
+	state.NodeConfig.EipCount = 1
+	state.NodeConfig.EipOpts = services.ElasticIPOpts{IPType: "5_bgp", BandwidthSize: 10, BandwidthType: bandwidthShareWhole}
+	state.NodeBandwidthID = "bandwidth-id"
+	body, err := requestBody(nodeSpec{PublicIP: nodeEipSpec(state)})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"count": float64(1),
+		"eip": map[string]interface{}{
+			"iptype":    "5_bgp",
+			"bandwidth": map[string]interface{}{"sharetype": "WHOLE", "id": "bandwidth-id"},
+		},
+	}, body["publicIP"])
+}
+
+func TestSyncNodeEips(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v3/projects/test/clusters/cluster-id/nodes":
+			_, _ = fmt.Fprint(w, `{"items": [
+				{"metadata": {"uid": "node-1"}, "status": {"PublicIP": "80.158.0.1"}},
+				{"metadata": {"uid": "node-2"}, "status": {"PublicIP": "80.158.0.2"}},
+				{"metadata": {"uid": "node-3"}, "status": {}}
+			]}`)
+		case r.URL.Path == "/v1/test/publicips" && r.Method == http.MethodGet:
+			if r.URL.Query().Get("marker") != "" {
+				_, _ = fmt.Fprint(w, `{"publicips": []}`)
+				return
+			}
+			// address of released eip-1 is reused by eip-5
+			_, _ = fmt.Fprint(w, `{"publicips": [
+				{"id": "eip-5", "public_ip_address": "80.158.0.1"},
+				{"id": "eip-2", "public_ip_address": "80.158.0.2"},
+				{"id": "eip-3", "public_ip_address": "80.158.0.3"}
+			]}`)
+		case r.URL.Path == "/v1/test/publicips/eip-4" && r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+
+	state := &clusterState{ClusterID: "cluster-id"}
+	state.NodeConfig.EipCount = 1
+	state.ManagedResources.NodeEips = []string{"eip-1", "eip-2", "eip-4"}
+	require.NoError(t, syncNodeEips(client, state))
+	assert.Equal(t, []string{"eip-5", "eip-2"}, state.ManagedResources.NodeEips)
+	assert.Equal(t, []string{"/v1/test/publicips/eip-1", "/v1/test/publicips/eip-4"}, deleted,
+		"EIPs of removed nodes are released, already released EIP is not an error")
+
+	deleted = nil
+	require.NoError(t, releaseNodeEips(client, state))
+	assert.Empty(t, state.ManagedResources.NodeEips)
+	assert.Equal(t, []string{"/v1/test/publicips/eip-5", "/v1/test/publicips/eip-2"}, deleted)
+}
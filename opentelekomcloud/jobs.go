@@ -0,0 +1,139 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// progressMetadataKey is the key of provisioning progress summary in ClusterInfo.Metadata
+	progressMetadataKey = "progress"
+
+	jobPhaseSuccess = "Success"
+	jobPhaseFailed  = "Failed"
+)
+
+// jobProgress is the summary of CCE job and its sub-jobs
+type jobProgress struct {
+	Type            string
+	Resource        string
+	Phase           string
+	Reason          string
+	SubJobs         int
+	FinishedSubJobs int
+	RunningSubJobs  []string
+	FailedSubJobs   []string
+}
+
+func (p jobProgress) String() string {
+	summary := fmt.Sprintf("%s %s: %s", p.Type, p.Resource, p.Phase)
+	if p.Reason != "" {
+		summary += fmt.Sprintf(" (%s)", p.Reason)
+	}
+	if p.SubJobs > 0 {
+		summary += fmt.Sprintf(", %d/%d sub-jobs finished", p.FinishedSubJobs, p.SubJobs)
+	}
+	if len(p.RunningSubJobs) > 0 {
+		summary += ", running: " + strings.Join(p.RunningSubJobs, ", ")
+	}
+	if len(p.FailedSubJobs) > 0 {
+		summary += ", failed: " + strings.Join(p.FailedSubJobs, ", ")
+	}
+	return summary
+}
+
+// summarizeJob returns progress of the job counting its sub-jobs
+func summarizeJob(job *nodes.Job) jobProgress {
+	progress := jobProgress{
+		Type:     job.Spec.Type,
+		Resource: job.Spec.ResourceName,
+		Phase:    job.Status.Phase,
+		Reason:   job.Status.Reason,
+		SubJobs:  len(job.Spec.SubJobs),
+	}
+	if progress.Resource == "" {
+		progress.Resource = job.Spec.ResourceID
+	}
+	for _, sub := range job.Spec.SubJobs {
+		switch sub.Status.Phase {
+		case jobPhaseSuccess:
+			progress.FinishedSubJobs++
+		case jobPhaseFailed:
+			progress.FinishedSubJobs++
+			progress.FailedSubJobs = append(progress.FailedSubJobs, fmt.Sprintf("%s: %s", sub.Spec.Type, sub.Status.Reason))
+		default:
+			if sub.Status.Phase != "" && sub.Status.Phase != "Init" {
+				progress.RunningSubJobs = append(progress.RunningSubJobs, sub.Spec.Type)
+			}
+		}
+	}
+	return progress
+}
+
+// trackJob polls the job, logs its progress and saves it to the state. Job polling is best effort,
+// failure to get the job doesn't fail the operation being waited for
+func trackJob(client *services.Client, state *clusterState, jobID string) {
+	if jobID == "" {
+		return
+	}
+	job, err := nodes.GetJobDetails(client.CCE, jobID).ExtractJob()
+	if err != nil {
+		logrus.WithError(err).WithField("job", jobID).Debug("Failed to get CCE job")
+		return
+	}
+	progress := summarizeJob(job)
+	entry := logrus.WithFields(logrus.Fields{
+		"job":      jobID,
+		"type":     progress.Type,
+		"resource": progress.Resource,
+		"phase":    progress.Phase,
+		"subjobs":  fmt.Sprintf("%d/%d", progress.FinishedSubJobs, progress.SubJobs),
+	})
+	if previous, ok := state.Progress[jobID]; ok && previous.String() == progress.String() {
+		entry.Debug("CCE job progress")
+	} else {
+		entry.Info("CCE job progress")
+	}
+	if state.Progress == nil {
+		state.Progress = map[string]jobProgress{}
+	}
+	state.Progress[jobID] = progress
+}
+
+// resetProgress forgets jobs of the previous operation, so only jobs of the running operation are reported
+func resetProgress(state *clusterState) {
+	state.Progress = nil
+	state.NodeJobIDs = nil
+}
+
+// addNodeJob records job ID of the node operation
+func addNodeJob(state *clusterState, jobID string) {
+	if jobID == "" {
+		return
+	}
+	for _, id := range state.NodeJobIDs {
+		if id == jobID {
+			return
+		}
+	}
+	state.NodeJobIDs = append(state.NodeJobIDs, jobID)
+}
+
+// progressSummary returns progress of all tracked jobs, one job per line
+func progressSummary(state *clusterState) string {
+	jobIDs := make([]string, 0, len(state.Progress))
+	for id := range state.Progress {
+		jobIDs = append(jobIDs, id)
+	}
+	sort.Strings(jobIDs)
+	lines := make([]string, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		lines = append(lines, fmt.Sprintf("%s %s", id, state.Progress[id]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,112 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subJob(jobType, phase, reason string) nodes.Job {
+	return nodes.Job{
+		Spec:   nodes.JobSpec{Type: jobType},
+		Status: nodes.JobStatus{Phase: phase, Reason: reason},
+	}
+}
+
+func TestSummarizeJob(t *testing.T) {
+	job := &nodes.Job{
+		Spec: nodes.JobSpec{
+			Type:       "CreateCluster",
+			ResourceID: "cluster-id",
+			SubJobs: []nodes.Job{
+				subJob("CreateVPCResource", jobPhaseSuccess, ""),
+				subJob("InstallMaster", "Running", ""),
+				subJob("InstallAddons", "Init", ""),
+				subJob("CreateELB", jobPhaseFailed, "quota exceeded"),
+			},
+		},
+		Status: nodes.JobStatus{Phase: "Running"},
+	}
+	progress := summarizeJob(job)
+	assert.Equal(t, jobProgress{
+		Type:            "CreateCluster",
+		Resource:        "cluster-id",
+		Phase:           "Running",
+		SubJobs:         4,
+		FinishedSubJobs: 2,
+		RunningSubJobs:  []string{"InstallMaster"},
+		FailedSubJobs:   []string{"CreateELB: quota exceeded"},
+	}, progress)
+	assert.Equal(t,
+		"CreateCluster cluster-id: Running, 2/4 sub-jobs finished, running: InstallMaster, failed: CreateELB: quota exceeded",
+		progress.String(),
+	)
+}
+
+func TestTrackJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v3/projects/test/jobs/job-id" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{
+			"metadata": {"uid": "job-id"},
+			"spec": {"type": "CreateNode", "resourceName": "node-1", "subJobs": [
+				{"spec": {"type": "CreateServer"}, "status": {"phase": "Success"}},
+				{"spec": {"type": "InstallNode"}, "status": {"phase": "Running"}}
+			]},
+			"status": {"phase": "Running"}
+		}`)
+	}))
+	defer server.Close()
+	client := fakeCCEClient(server.URL)
+
+	state := &clusterState{}
+	trackJob(client, state, "")
+	trackJob(client, state, "missing-id")
+	assert.Empty(t, state.Progress, "failed polling is not recorded")
+
+	trackJob(client, state, "job-id")
+	require.Contains(t, state.Progress, "job-id")
+	assert.Equal(t, "CreateNode node-1: Running, 1/2 sub-jobs finished, running: InstallNode", state.Progress["job-id"].String())
+}
+
+func TestAddNodeJob(t *testing.T) {
+	state := &clusterState{}
+	addNodeJob(state, "job-1")
+	addNodeJob(state, "")
+	addNodeJob(state, "job-2")
+	addNodeJob(state, "job-1")
+	assert.Equal(t, []string{"job-1", "job-2"}, state.NodeJobIDs)
+
+	state.Progress = map[string]jobProgress{"job-1": {Phase: jobPhaseSuccess}}
+	resetProgress(state)
+	assert.Empty(t, state.NodeJobIDs, "jobs of the previous operation are forgotten")
+	assert.Empty(t, state.Progress)
+}
+
+func TestProgressMetadata(t *testing.T) {
+	state := &clusterState{Progress: map[string]jobProgress{
+		"job-2": {Type: "CreateNode", Resource: "node-1", Phase: jobPhaseSuccess},
+		"job-1": {Type: "CreateCluster", Resource: "test", Phase: jobPhaseSuccess},
+	}}
+	info, err := stateToInfo(state, &types.ClusterInfo{})
+	require.NoError(t, err)
+	assert.Equal(t,
+		"job-1 CreateCluster test: Success\njob-2 CreateNode node-1: Success",
+		info.Metadata[progressMetadataKey],
+	)
+
+	resetProgress(state)
+	info, err = stateToInfo(state, info)
+	require.NoError(t, err)
+	assert.NotContains(t, info.Metadata, progressMetadataKey)
+}
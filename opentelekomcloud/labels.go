@@ -0,0 +1,273 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var taintEffects = []string{
+	string(v1.TaintEffectNoSchedule), string(v1.TaintEffectPreferNoSchedule), string(v1.TaintEffectNoExecute),
+}
+
+// parseLabels parses labels in `key=value` format
+func parseLabels(values []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, label := range values {
+		lab := strings.Split(label, "=")
+		if len(lab) != 2 {
+			return nil, fmt.Errorf("invalid label value: %s", label)
+		}
+		labels[lab[0]] = lab[1]
+	}
+	return labels, nil
+}
+
+// parseTaints parses taints in `key=value:Effect` format, value can be omitted
+func parseTaints(values []string) ([]nodes.TaintSpec, error) {
+	var taints []nodes.TaintSpec
+	for _, value := range values {
+		sep := strings.LastIndex(value, ":")
+		if sep == -1 {
+			return nil, fmt.Errorf("invalid taint value %s, should be in key=value:Effect format", value)
+		}
+		taint := nodes.TaintSpec{Effect: value[sep+1:]}
+		taint.Key, taint.Value, _ = strings.Cut(value[:sep], "=")
+		if taint.Key == "" {
+			return nil, fmt.Errorf("invalid taint value %s, key is required", value)
+		}
+		if !isTaintEffect(taint.Effect) {
+			return nil, fmt.Errorf("invalid taint effect %s, should be one of %s", taint.Effect, strings.Join(taintEffects, ", "))
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+func isTaintEffect(effect string) bool {
+	for _, e := range taintEffects {
+		if e == effect {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeLabels returns labels of the pool nodes, node labels override cluster labels and pool labels override both
+func nodeLabels(state *clusterState, pool *nodePool) map[string]string {
+	labels := map[string]string{}
+	for _, source := range []map[string]string{state.ClusterLabels, state.NodeLabels, pool.Labels} {
+		for key, value := range source {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// nodeTaintSpecs returns taints of the pool nodes, pool taints are added to cluster node taints
+func nodeTaintSpecs(state *clusterState, pool *nodePool) []nodes.TaintSpec {
+	taints := append([]nodes.TaintSpec{}, state.NodeTaints...)
+	for _, taint := range pool.Taints {
+		if !containsTaint(taints, taint) {
+			taints = append(taints, taint)
+		}
+	}
+	if len(taints) == 0 {
+		return nil
+	}
+	return taints
+}
+
+// updateClusterLabels replaces CCE cluster metadata labels, cluster description is kept
+func updateClusterLabels(client *services.Client, state *clusterState, labels map[string]string) error {
+	return clusters.Update(client.CCE, state.ClusterID, clusterUpdateOpts{
+		Metadata: clusterUpdateMetadata{Labels: cceClusterLabels(labels, state.CreationToken)},
+		Spec:     clusters.UpdateSpec{Description: state.Description},
+	}).Err
+}
+
+// labelsEqual compares label maps, nil and empty maps are equal
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// taintsEqual compares taint lists ignoring the order
+func taintsEqual(a, b []nodes.TaintSpec) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, taint := range a {
+		if !containsTaint(b, taint) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTaint(taints []nodes.TaintSpec, taint nodes.TaintSpec) bool {
+	for _, t := range taints {
+		if t == taint {
+			return true
+		}
+	}
+	return false
+}
+
+// labelsPatch returns merge patch changing `previous` labels to `labels`, nil is returned if nothing changes
+func labelsPatch(current, previous, labels map[string]string) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for key := range previous {
+		if _, ok := labels[key]; !ok {
+			if _, exists := current[key]; exists {
+				changes[key] = nil
+			}
+		}
+	}
+	for key, value := range labels {
+		if v, ok := current[key]; !ok || v != value {
+			changes[key] = value
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": changes},
+	}
+}
+
+// reconcileNodeLabels sets `labels` on the nodes and removes `previous` labels which are not in `labels`,
+// nodes which are not registered in Kubernetes are skipped
+func reconcileNodeLabels(ctx context.Context, clientSet kubernetes.Interface, nodeNames []string, previous, labels map[string]string) error {
+	for _, name := range nodeNames {
+		node, err := clientSet.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %s", name, err)
+		}
+		patch := labelsPatch(node.Labels, previous, labels)
+		if patch == nil {
+			continue
+		}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Updating labels of node %s", node.Name)
+		if _, err := clientSet.CoreV1().Nodes().Patch(ctx, node.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to update labels of node %s: %s", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// nodeTaints returns taints of the node with `previous` taints replaced by `taints`
+func nodeTaints(current []v1.Taint, previous, taints []nodes.TaintSpec) []v1.Taint {
+	var result []v1.Taint
+	for _, taint := range current {
+		spec := nodes.TaintSpec{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)}
+		if containsTaint(previous, spec) || containsTaint(taints, spec) {
+			continue
+		}
+		result = append(result, taint)
+	}
+	for _, taint := range taints {
+		result = append(result, v1.Taint{Key: taint.Key, Value: taint.Value, Effect: v1.TaintEffect(taint.Effect)})
+	}
+	return result
+}
+
+// reconcileNodeTaints sets `taints` on the nodes and removes `previous` taints which are not in `taints`,
+// nodes which are not registered in Kubernetes are skipped
+func reconcileNodeTaints(ctx context.Context, clientSet kubernetes.Interface, nodeNames []string, previous, taints []nodes.TaintSpec) error {
+	for _, name := range nodeNames {
+		node, err := clientSet.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %s", name, err)
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{"taints": nodeTaints(node.Spec.Taints, previous, taints)},
+		})
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Updating taints of node %s", node.Name)
+		if _, err := clientSet.CoreV1().Nodes().Patch(ctx, node.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to update taints of node %s: %s", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// poolNodeSettings are labels and taints of the pool nodes
+type poolNodeSettings struct {
+	Labels map[string]string
+	Taints []nodes.TaintSpec
+}
+
+// currentNodeSettings returns labels and taints of nodes of each cluster pool by pool name
+func currentNodeSettings(state *clusterState) map[string]poolNodeSettings {
+	settings := map[string]poolNodeSettings{}
+	for i := range state.NodePools {
+		pool := &state.NodePools[i]
+		settings[pool.Name] = poolNodeSettings{Labels: nodeLabels(state, pool), Taints: nodeTaintSpecs(state, pool)}
+	}
+	return settings
+}
+
+// reconcilePoolNodes applies current labels and taints to the node template and nodes of the pool,
+// `previous` labels and taints which are not set anymore are removed from the nodes
+func reconcilePoolNodes(ctx context.Context, client *services.Client, clientSet kubernetes.Interface, state *clusterState, pool *nodePool, previous poolNodeSettings) error {
+	labels, taints := nodeLabels(state, pool), nodeTaintSpecs(state, pool)
+	labelsChanged := !labelsEqual(previous.Labels, labels)
+	taintsChanged := !taintsEqual(previous.Taints, taints)
+	if !labelsChanged && !taintsChanged {
+		return nil
+	}
+	logrus.Infof("Updating node labels and taints of pool %s", pool.Name)
+	if !pool.isNodeGroup() {
+		if err := updateNodePoolTemplate(client, state, pool); err != nil {
+			return fmt.Errorf("failed to update node template of pool %s: %s", pool.Name, err)
+		}
+	}
+	cceNodes, err := poolNodes(client, state, pool)
+	if err != nil {
+		return err
+	}
+	nodeNames := kubeNodeNames(cceNodes)
+	if labelsChanged {
+		if err := reconcileNodeLabels(ctx, clientSet, nodeNames, previous.Labels, labels); err != nil {
+			return err
+		}
+	}
+	if taintsChanged {
+		if err := reconcileNodeTaints(ctx, clientSet, nodeNames, previous.Taints, taints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusterUpdateOpts(t *testing.T) {
+	state := &clusterState{ClusterID: "cluster-id", Description: "test"}
+	body, err := clusterUpdateOpts{
+		Metadata: clusterUpdateMetadata{Labels: map[string]string{"env": "prod"}},
+		Spec:     clusters.UpdateSpec{Description: state.Description},
+	}.ToClusterUpdateMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"labels": map[string]interface{}{"env": "prod"}}, body["metadata"])
+	assert.Equal(t, map[string]interface{}{"description": "test"}, body["spec"])
+}
+
+func TestReconcileNodeLabels(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{
+			"kubernetes.io/hostname": "node-1", "env": "dev", "team": "a",
+		}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{
+			"kubernetes.io/hostname": "node-2",
+		}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-pool", Labels: map[string]string{"env": "dev"}}},
+	)
+	previous := map[string]string{"env": "dev", "team": "a"}
+	labels := map[string]string{"env": "prod", "tier": "web"}
+	nodeNames := []string{"node-1", "node-2", "not-registered"}
+	require.NoError(t, reconcileNodeLabels(context.Background(), clientSet, nodeNames, previous, labels))
+
+	for _, name := range []string{"node-1", "node-2"} {
+		node, err := clientSet.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"kubernetes.io/hostname": name, "env": "prod", "tier": "web",
+		}, node.Labels)
+	}
+	other, err := clientSet.CoreV1().Nodes().Get(context.Background(), "other-pool", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "dev"}, other.Labels, "nodes of other pools are not changed")
+
+	assert.True(t, labelsEqual(nil, map[string]string{}))
+	assert.False(t, labelsEqual(previous, labels))
+}
+
+func TestParseTaints(t *testing.T) {
+	taints, err := parseTaints([]string{"dedicated=gpu:NoSchedule", "maintenance:NoExecute"})
+	require.NoError(t, err)
+	assert.Equal(t, []nodes.TaintSpec{
+		{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"},
+		{Key: "maintenance", Effect: "NoExecute"},
+	}, taints)
+
+	for _, value := range []string{"dedicated=gpu", "dedicated=gpu:Never", "=gpu:NoSchedule"} {
+		_, err := parseTaints([]string{value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestReconcileNodeTaints(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Spec: v1.NodeSpec{Taints: []v1.Taint{
+				{Key: "node.kubernetes.io/unreachable", Effect: v1.TaintEffectNoExecute},
+				{Key: "dedicated", Value: "db", Effect: v1.TaintEffectNoSchedule},
+			}},
+		},
+	)
+	previous := []nodes.TaintSpec{{Key: "dedicated", Value: "db", Effect: "NoSchedule"}}
+	taints := []nodes.TaintSpec{{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}}
+	require.NoError(t, reconcileNodeTaints(context.Background(), clientSet, []string{"node-1"}, previous, taints))
+
+	node, err := clientSet.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []v1.Taint{
+		{Key: "node.kubernetes.io/unreachable", Effect: v1.TaintEffectNoExecute},
+		{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+	}, node.Spec.Taints)
+
+	assert.True(t, taintsEqual(taints, taints))
+	assert.False(t, taintsEqual(previous, taints))
+}
+
+func TestPoolNodeSettings(t *testing.T) {
+	state := &clusterState{
+		ClusterLabels: map[string]string{"env": "prod", "role": "web"},
+		NodeLabels:    map[string]string{"role": "gpu", "tier": "1"},
+		NodeTaints:    []nodes.TaintSpec{{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}},
+	}
+	pool := &nodePool{
+		Labels: map[string]string{"tier": "2"},
+		Taints: []nodes.TaintSpec{
+			{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"},
+			{Key: "maintenance", Effect: "NoExecute"},
+		},
+	}
+	assert.Equal(t, map[string]string{"env": "prod", "role": "gpu", "tier": "2"}, nodeLabels(state, pool))
+	assert.Equal(t, []nodes.TaintSpec{
+		{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"},
+		{Key: "maintenance", Effect: "NoExecute"},
+	}, nodeTaintSpecs(state, pool))
+	assert.Equal(t, map[string]string{"env": "prod", "role": "gpu", "tier": "1"}, nodeLabels(state, &nodePool{}))
+	assert.Nil(t, nodeTaintSpecs(&clusterState{}, &nodePool{}))
+}
@@ -0,0 +1,91 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v1/subnets"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	elbIDAnnotation    = "kubernetes.io/elb.id"
+	elbClassAnnotation = "kubernetes.io/elb.class"
+	// elbAutoCreateAnnotation requests new ELB for the service
+	elbAutoCreateAnnotation = "kubernetes.io/elb.autocreate"
+	// elbClassUnion is the class of shared ELB
+	elbClassUnion = "union"
+
+	elbProvider = "OTC ELB"
+)
+
+var elbProtocols = []string{"TCP", "UDP"}
+
+// validateLoadBalancer checks that ELB exists, is active and belongs to the cluster VPC
+func validateLoadBalancer(client *services.Client, state *clusterState) error {
+	lb, err := client.GetLoadBalancerDetails(state.LoadBalancerID)
+	if err != nil {
+		if _, ok := err.(golangsdk.ErrDefault404); ok {
+			return fmt.Errorf("load balancer %s doesn't exist", state.LoadBalancerID)
+		}
+		return fmt.Errorf("failed to get load balancer %s: %s", state.LoadBalancerID, err)
+	}
+	if lb.ProvisioningStatus != "ACTIVE" {
+		return fmt.Errorf("load balancer %s is in %s state", lb.ID, lb.ProvisioningStatus)
+	}
+	vpcSubnets, err := subnets.List(client.VPC, subnets.ListOpts{VpcID: state.VpcID})
+	if err != nil {
+		return fmt.Errorf("failed to list subnets of VPC %s: %s", state.VpcID, err)
+	}
+	for _, subnet := range vpcSubnets {
+		if subnet.SubnetID == lb.VipSubnetID {
+			return nil
+		}
+	}
+	return fmt.Errorf("load balancer %s doesn't belong to VPC %s", lb.ID, state.VpcID)
+}
+
+// usesOwnLoadBalancer returns true if the service has ELB set by annotations or is already provisioned on an ELB
+func usesOwnLoadBalancer(svc *v1.Service) bool {
+	return svc.Annotations[elbIDAnnotation] != "" ||
+		svc.Annotations[elbAutoCreateAnnotation] != "" ||
+		len(svc.Status.LoadBalancer.Ingress) > 0
+}
+
+// assignLoadBalancer makes `LoadBalancer` services without explicitly set or already provisioned ELB use the ELB
+// with `lbID`
+func assignLoadBalancer(ctx context.Context, clientSet kubernetes.Interface, lbID string) error {
+	svcList, err := clientSet.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list services: %s", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				elbIDAnnotation:    lbID,
+				elbClassAnnotation: elbClassUnion,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, svc := range svcList.Items {
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer || usesOwnLoadBalancer(&svc) {
+			continue
+		}
+		logrus.Infof("Assigning load balancer %s to service %s/%s", lbID, svc.Namespace, svc.Name)
+		_, err := clientSet.CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to assign load balancer to service %s/%s: %s", svc.Namespace, svc.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAssignLoadBalancer(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "own-lb", Namespace: "apps", Annotations: map[string]string{elbIDAnnotation: "other"}},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "autocreate", Namespace: "apps", Annotations: map[string]string{
+				elbAutoCreateAnnotation: `{"type": "public", "name": "web"}`,
+			}},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "provisioned", Namespace: "apps"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			Status: v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: "80.158.1.1"}},
+			}},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "apps"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeClusterIP},
+		},
+	)
+	ctx := context.Background()
+	require.NoError(t, assignLoadBalancer(ctx, clientSet, "lb-id"))
+
+	services := clientSet.CoreV1().Services("apps")
+	web, err := services.Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{elbIDAnnotation: "lb-id", elbClassAnnotation: elbClassUnion}, web.Annotations)
+
+	ownLB, err := services.Get(ctx, "own-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "other", ownLB.Annotations[elbIDAnnotation])
+
+	autoCreate, err := services.Get(ctx, "autocreate", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, autoCreate.Annotations, elbIDAnnotation, "service requesting new ELB is kept")
+
+	provisioned, err := services.Get(ctx, "provisioned", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, provisioned.Annotations, "service already provisioned on ELB is kept")
+
+	internal, err := services.Get(ctx, "internal", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, internal.Annotations)
+}
+
+func TestGetK8SCapabilities(t *testing.T) {
+	driver := NewDriver()
+	ctx := context.Background()
+
+	capabilities, err := driver.GetK8SCapabilities(ctx, &types.DriverOptions{})
+	require.NoError(t, err)
+	assert.False(t, capabilities.L4LoadBalancer.Enabled)
+
+	opts := &types.DriverOptions{StringOptions: map[string]string{"load-balancer": "lb-id"}}
+	capabilities, err = driver.GetK8SCapabilities(ctx, opts)
+	require.NoError(t, err)
+	assert.True(t, capabilities.L4LoadBalancer.Enabled)
+	assert.Equal(t, elbProvider, capabilities.L4LoadBalancer.Provider)
+	assert.Equal(t, elbProtocols, capabilities.L4LoadBalancer.ProtocolsSupported)
+}
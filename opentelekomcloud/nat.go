@@ -0,0 +1,251 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v1/eips"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v2/extensions/natgateways"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v2/extensions/snatrules"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	natPollInterval = 5
+	natStatusActive = "ACTIVE"
+	eipStatusDown   = "DOWN"
+)
+
+// NAT gateway specs: small, medium, large and extra-large
+var natGatewaySpecs = []string{"1", "2", "3", "4"}
+
+func validateNatGateway(state *clusterState) error {
+	if !state.CreateNatGateway {
+		return nil
+	}
+	for _, spec := range natGatewaySpecs {
+		if state.NatGatewaySpec == spec {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid nat-gateway-spec %s, should be one of 1 (small), 2 (medium), 3 (large), 4 (extra-large)",
+		state.NatGatewaySpec)
+}
+
+func natClient(client *services.Client, region string) (*golangsdk.ServiceClient, error) {
+	nat, err := openstack.NewNatV2(client.Provider, golangsdk.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init NAT client: %s", err)
+	}
+	return nat, nil
+}
+
+// createNatGateway creates NAT gateway with SNAT rule giving cluster subnet access to the internet.
+// Created resources are recorded in managed resources one by one, so they can be removed on failure
+func createNatGateway(ctx context.Context, client *services.Client, state *clusterState) error {
+	nat, err := natClient(client, state.Region)
+	if err != nil {
+		return err
+	}
+	if err := adoptNatGateway(client, nat, state); err != nil {
+		return err
+	}
+	if state.NatGatewayID == "" {
+		logrus.Infof("Creating NAT gateway for subnet %s", state.SubnetID)
+		gateway, err := natgateways.Create(nat, natgateways.CreateOpts{
+			Name:              natGatewayName(state),
+			Description:       "NAT gateway of CCE cluster " + state.ClusterName,
+			Spec:              state.NatGatewaySpec,
+			RouterID:          state.VpcID,
+			InternalNetworkID: state.SubnetID,
+		}).Extract()
+		if err != nil {
+			return fmt.Errorf("failed to create NAT gateway: %s", err)
+		}
+		state.NatGatewayID = gateway.ID
+		state.ManagedResources.NatGateway = true
+	}
+	if err := waitForNatGateway(ctx, nat, state.NatGatewayID, state.Timeouts.Network); err != nil {
+		return err
+	}
+	if state.NatEipID == "" {
+		eip, err := createNamedEip(client, services.ElasticIPOpts{}, natEipName(state))
+		if err != nil {
+			return fmt.Errorf("failed to create NAT gateway EIP: %s", err)
+		}
+		state.NatEipID = eip.ID
+		state.ManagedResources.NatEip = true
+		if err := waitForEip(ctx, client, eip.ID, state.Timeouts.Network); err != nil {
+			return fmt.Errorf("failed waiting for NAT gateway EIP: %s", err)
+		}
+	}
+	if state.SnatRuleID == "" {
+		rule, err := snatrules.Create(nat, snatrules.CreateOpts{
+			NatGatewayID: state.NatGatewayID,
+			NetworkID:    state.SubnetID,
+			FloatingIPID: state.NatEipID,
+		}).Extract()
+		if err != nil {
+			return fmt.Errorf("failed to create SNAT rule: %s", err)
+		}
+		state.SnatRuleID = rule.ID
+		state.ManagedResources.SnatRule = true
+	}
+	return waitFor(ctx, state.Timeouts.Network, natPollInterval, func() (bool, error) {
+		rule, err := snatrules.Get(nat, state.SnatRuleID).Extract()
+		if err != nil {
+			return true, err
+		}
+		return rule.Status == natStatusActive, nil
+	})
+}
+
+func natGatewayName(state *clusterState) string {
+	return state.ClusterName + "-nat"
+}
+
+// adoptNatGateway fills IDs of NAT gateway, its EIP and SNAT rule left by previous Create attempt
+// and marks them as managed, so they are reused and removed with the cluster
+func adoptNatGateway(client *services.Client, nat *golangsdk.ServiceClient, state *clusterState) error {
+	resources := &state.ManagedResources
+	if state.NatGatewayID == "" {
+		pages, err := natgateways.List(nat, natgateways.ListOpts{Name: natGatewayName(state), RouterID: state.VpcID}).AllPages()
+		if err != nil {
+			return fmt.Errorf("failed to list NAT gateways: %s", err)
+		}
+		gateways, err := natgateways.ExtractNatGateways(pages)
+		if err != nil {
+			return err
+		}
+		if len(gateways) > 0 {
+			logrus.Infof("Using NAT gateway %s created by previous attempt", gateways[0].ID)
+			state.NatGatewayID = gateways[0].ID
+			resources.NatGateway = true
+		}
+	}
+	if state.NatEipID == "" {
+		eip, err := findNamedEip(client, natEipName(state))
+		if err != nil {
+			return err
+		}
+		if eip != nil {
+			logrus.Infof("Using NAT gateway EIP %s created by previous attempt", eip.PublicipAddress)
+			state.NatEipID = eip.PublicipId
+			resources.NatEip = true
+		}
+	}
+	if state.SnatRuleID == "" && resources.NatGateway {
+		rule, err := findSnatRule(nat, state.NatGatewayID, state.SubnetID)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			logrus.Infof("Using SNAT rule %s created by previous attempt", rule.ID)
+			state.SnatRuleID = rule.ID
+			resources.SnatRule = true
+		}
+	}
+	return nil
+}
+
+// findSnatRule returns SNAT rule of the NAT gateway for the subnet, nil is returned if there is no such rule
+func findSnatRule(nat *golangsdk.ServiceClient, gatewayID, subnetID string) (*snatrules.SnatRule, error) {
+	var body struct {
+		SnatRules []snatrules.SnatRule `json:"snat_rules"`
+	}
+	url := nat.ServiceURL("snat_rules") + "?nat_gateway_id=" + gatewayID
+	if _, err := nat.Get(url, &body, nil); err != nil {
+		return nil, fmt.Errorf("failed to list SNAT rules of NAT gateway %s: %s", gatewayID, err)
+	}
+	for _, rule := range body.SnatRules {
+		if rule.NetworkID == subnetID {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+func waitForNatGateway(ctx context.Context, nat *golangsdk.ServiceClient, id string, timeout int) error {
+	logrus.Infof("Waiting for NAT gateway %s to become active", id)
+	return waitFor(ctx, timeout, natPollInterval, func() (bool, error) {
+		gateway, err := natgateways.Get(nat, id).Extract()
+		if err != nil {
+			return true, err
+		}
+		return gateway.Status == natStatusActive, nil
+	})
+}
+
+// waitForEip waits until allocated EIP is ready to be bound, unbound EIP is DOWN
+func waitForEip(ctx context.Context, client *services.Client, id string, timeout int) error {
+	logrus.Infof("Waiting for EIP %s to become active", id)
+	return waitFor(ctx, timeout, natPollInterval, func() (bool, error) {
+		eip, err := eips.Get(client.VPC, id).Extract()
+		if err != nil {
+			return true, err
+		}
+		return eip.Status == natStatusActive || eip.Status == eipStatusDown, nil
+	})
+}
+
+// deleteSnatRule deletes SNAT rule and waits until it is removed, missing rule is not an error
+func deleteSnatRule(ctx context.Context, nat *golangsdk.ServiceClient, id string, timeout int) error {
+	if err := snatrules.Delete(nat, id).Err; err != nil && !isNotFound(err) {
+		return err
+	}
+	return waitFor(ctx, timeout, natPollInterval, func() (bool, error) {
+		_, err := snatrules.Get(nat, id).Extract()
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err != nil, err
+	})
+}
+
+// deleteNatGateway deletes NAT gateway and waits until it is removed, missing gateway is not an error
+func deleteNatGateway(ctx context.Context, nat *golangsdk.ServiceClient, id string, timeout int) error {
+	if err := natgateways.Delete(nat, id).Err; err != nil && !isNotFound(err) {
+		return err
+	}
+	return waitFor(ctx, timeout, natPollInterval, func() (bool, error) {
+		_, err := natgateways.Get(nat, id).Extract()
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err != nil, err
+	})
+}
+
+// cleanupNatGateway removes NAT resources recorded in managed resources
+func cleanupNatGateway(ctx context.Context, client *services.Client, state *clusterState) error {
+	resources := &state.ManagedResources
+	if !resources.SnatRule && !resources.NatGateway && !resources.NatEip {
+		return nil
+	}
+	nat, err := natClient(client, state.Region)
+	if err != nil {
+		return err
+	}
+	if resources.SnatRule {
+		if err := deleteSnatRule(ctx, nat, state.SnatRuleID, state.Timeouts.Network); err != nil {
+			return fmt.Errorf("failed to delete SNAT rule %s: %s", state.SnatRuleID, err)
+		}
+		resources.SnatRule = false
+	}
+	if resources.NatGateway {
+		if err := deleteNatGateway(ctx, nat, state.NatGatewayID, state.Timeouts.Network); err != nil {
+			return fmt.Errorf("failed to delete NAT gateway %s: %s", state.NatGatewayID, err)
+		}
+		resources.NatGateway = false
+	}
+	if resources.NatEip {
+		if err := eips.Delete(client.VPC, state.NatEipID).Err; err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to release NAT gateway EIP %s: %s", state.NatEipID, err)
+		}
+		resources.NatEip = false
+	}
+	return nil
+}
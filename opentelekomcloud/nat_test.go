@@ -0,0 +1,74 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNatGateway(t *testing.T) {
+	assert.NoError(t, validateNatGateway(&clusterState{NatGatewaySpec: "0"}))
+	assert.NoError(t, validateNatGateway(&clusterState{CreateNatGateway: true, NatGatewaySpec: "2"}))
+	assert.Error(t, validateNatGateway(&clusterState{CreateNatGateway: true, NatGatewaySpec: "small"}))
+}
+
+func TestDeleteNatGateway(t *testing.T) {
+	deleted := map[string]bool{}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if deleted[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			deleted[r.URL.Path] = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"snat_rule": {"id": "rule-id"}, "nat_gateway": {"id": "nat-id"}}`)
+	}))
+	defer server.Close()
+	nat := &golangsdk.ServiceClient{
+		ProviderClient: &golangsdk.ProviderClient{HTTPClient: http.Client{}},
+		Endpoint:       server.URL + "/",
+	}
+
+	require.NoError(t, deleteSnatRule(context.Background(), nat, "rule-id", 60))
+	require.NoError(t, deleteNatGateway(context.Background(), nat, "nat-id", 60))
+	assert.Equal(t, []string{
+		"DELETE /snat_rules/rule-id", "GET /snat_rules/rule-id",
+		"DELETE /nat_gateways/nat-id", "GET /nat_gateways/nat-id",
+	}, requests)
+
+	requests = nil
+	require.NoError(t, deleteNatGateway(context.Background(), nat, "nat-id", 60), "removed gateway is not an error")
+	assert.Equal(t, []string{"DELETE /nat_gateways/nat-id", "GET /nat_gateways/nat-id"}, requests)
+}
+
+func TestWaitForEip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/test/publicips/eip-id":
+			_, _ = fmt.Fprint(w, `{"publicip": {"id": "eip-id", "status": "DOWN"}}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"publicip": {"id": "pending-id", "status": "PENDING_CREATE"}}`)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+
+	require.NoError(t, waitForEip(context.Background(), client, "eip-id", 60))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, waitForEip(ctx, client, "pending-id", 60), context.Canceled)
+	assert.Error(t, waitForEip(context.Background(), client, "pending-id", 0), "wait is limited by network timeout")
+}
@@ -0,0 +1,185 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v1/subnets"
+)
+
+// default OTC DNS servers
+var defaultDNS = []string{"100.125.4.25", "100.125.129.199"}
+
+const (
+	// containerNetworkModeENI is the network mode of CCE Turbo clusters, containers use ENIs from VPC subnets
+	containerNetworkModeENI = "eni"
+
+	clusterCategoryTurbo = "Turbo"
+
+	networkPollInterval = 5
+)
+
+// validateContainerNetwork checks that container network settings are consistent with cluster type and flavor
+func validateContainerNetwork(state *clusterState) error {
+	mode := state.ContainerNetworkMode
+	switch mode {
+	case services.ContainerNetworkModeOverlay, services.ContainerNetworkModeVPC:
+		if state.ClusterType == services.ClusterTypeBMS {
+			return fmt.Errorf("container-network-mode %s is not supported by %s clusters", mode, state.ClusterType)
+		}
+	case services.ContainerNetworkModeUnderlay:
+		if state.ClusterType != services.ClusterTypeBMS {
+			return fmt.Errorf("container-network-mode %s is supported only by %s clusters", mode, services.ClusterTypeBMS)
+		}
+	case containerNetworkModeENI:
+		if state.ClusterType != services.ClusterTypeECS {
+			return fmt.Errorf("container-network-mode %s is supported only by %s clusters", mode, services.ClusterTypeECS)
+		}
+		if !strings.HasPrefix(state.ClusterFlavor, haClusterFlavorPrefix) {
+			return fmt.Errorf("container-network-mode %s requires HA cluster flavor %s*", mode, haClusterFlavorPrefix)
+		}
+		if len(state.EniSubnetIDs) == 0 {
+			return fmt.Errorf("eni-subnet-ids are required for container-network-mode %s", mode)
+		}
+		if len(state.EniSubnetCIDRs) != 0 && len(state.EniSubnetCIDRs) != len(state.EniSubnetIDs) {
+			return fmt.Errorf("eni-subnet-cidrs should contain CIDR for each of eni-subnet-ids")
+		}
+		for _, cidr := range state.EniSubnetCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid eni-subnet-cidrs value %s: %s", cidr, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported container-network-mode %s, should be one of %s", mode, strings.Join([]string{
+			services.ContainerNetworkModeOverlay, services.ContainerNetworkModeVPC,
+			services.ContainerNetworkModeUnderlay, containerNetworkModeENI,
+		}, ", "))
+	}
+	if len(state.EniSubnetIDs) != 0 || len(state.EniSubnetCIDRs) != 0 {
+		return fmt.Errorf("ENI subnets can be used only with container-network-mode %s", containerNetworkModeENI)
+	}
+	return nil
+}
+
+// validateEniSubnets checks that all ENI subnets belong to the cluster VPC
+func validateEniSubnets(client *services.Client, state *clusterState) error {
+	vpcSubnets, err := subnets.List(client.VPC, subnets.ListOpts{VpcID: state.VpcID})
+	if err != nil {
+		return fmt.Errorf("failed to list subnets of VPC %s: %s", state.VpcID, err)
+	}
+	existing := map[string]bool{}
+	for _, subnet := range vpcSubnets {
+		existing[subnet.SubnetID] = true
+	}
+	for _, id := range state.EniSubnetIDs {
+		if !existing[id] {
+			return fmt.Errorf("ENI subnet %s doesn't belong to VPC %s", id, state.VpcID)
+		}
+	}
+	return nil
+}
+
+// clusterCategory returns CCE cluster category, eni network mode is available only for CCE Turbo clusters
+func clusterCategory(state *clusterState) string {
+	if state.ContainerNetworkMode == containerNetworkModeENI {
+		return clusterCategoryTurbo
+	}
+	return ""
+}
+
+// eniNetwork returns ENI network spec of the cluster, nil is returned for non-ENI clusters
+func eniNetwork(state *clusterState) *eniNetworkSpec {
+	if state.ContainerNetworkMode != containerNetworkModeENI {
+		return nil
+	}
+	spec := &eniNetworkSpec{
+		EniSubnetID:   strings.Join(state.EniSubnetIDs, ";"),
+		EniSubnetCIDR: strings.Join(state.EniSubnetCIDRs, ";"),
+	}
+	for _, id := range state.EniSubnetIDs {
+		spec.Subnets = append(spec.Subnets, eniSubnet{SubnetID: id})
+	}
+	return spec
+}
+
+// createSubnet creates subnet with given CIDR in the VPC, first address of the CIDR is used as gateway
+func createSubnet(client *services.Client, vpcID, name, cidr string) (*subnets.Subnet, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet CIDR %s: %s", cidr, err)
+	}
+	gateway := ip.Mask(ipNet.Mask).To4()
+	if gateway == nil {
+		return nil, fmt.Errorf("subnet CIDR %s is not IPv4 CIDR", cidr)
+	}
+	gateway[3]++
+	enableDHCP := true
+	return subnets.Create(client.VPC, subnets.CreateOpts{
+		VpcID:      vpcID,
+		Name:       name,
+		CIDR:       ipNet.String(),
+		DNSList:    defaultDNS,
+		GatewayIP:  gateway.String(),
+		EnableDHCP: &enableDHCP,
+	}).Extract()
+}
+
+// waitForVPCStatus waits until VPC is in the given status, the wait is limited by network timeout
+func waitForVPCStatus(ctx context.Context, client *services.Client, state *clusterState, vpcID, status string) error {
+	return waitFor(ctx, state.Timeouts.Network, networkPollInterval, func() (bool, error) {
+		vpc, err := client.GetVPCDetails(vpcID)
+		if err != nil {
+			return true, err
+		}
+		if vpc.Status == "ERROR" {
+			return true, fmt.Errorf("VPC %s is in error state", vpcID)
+		}
+		return vpc.Status == status, nil
+	})
+}
+
+// waitForSubnetStatus waits until subnet is in the given status, the wait is limited by network timeout
+func waitForSubnetStatus(ctx context.Context, client *services.Client, state *clusterState, subnetID, status string) error {
+	return waitFor(ctx, state.Timeouts.Network, networkPollInterval, func() (bool, error) {
+		subnet, err := client.GetSubnetStatus(subnetID)
+		if err != nil {
+			return true, err
+		}
+		if subnet.Status == "ERROR" {
+			return true, fmt.Errorf("subnet %s is in error state", subnetID)
+		}
+		return subnet.Status == status, nil
+	})
+}
+
+// deleteSubnet deletes subnet and waits until it is removed, missing subnet is not an error
+func deleteSubnet(ctx context.Context, client *services.Client, state *clusterState, vpcID, subnetID string) error {
+	if err := client.DeleteSubnet(vpcID, subnetID); err != nil && !isNotFound(err) {
+		return err
+	}
+	return waitFor(ctx, state.Timeouts.Network, networkPollInterval, func() (bool, error) {
+		_, err := client.GetSubnetStatus(subnetID)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err != nil, err
+	})
+}
+
+// deleteVPC deletes VPC and waits until it is removed, missing VPC is not an error
+func deleteVPC(ctx context.Context, client *services.Client, state *clusterState, vpcID string) error {
+	if err := client.DeleteVPC(vpcID); err != nil && !isNotFound(err) {
+		return err
+	}
+	return waitFor(ctx, state.Timeouts.Network, networkPollInterval, func() (bool, error) {
+		_, err := client.GetVPCDetails(vpcID)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err != nil, err
+	})
+}
@@ -0,0 +1,127 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContainerNetwork(t *testing.T) {
+	cases := []struct {
+		name  string
+		state clusterState
+		valid bool
+	}{
+		{"overlay", clusterState{ClusterType: services.ClusterTypeECS, ContainerNetworkMode: "overlay_l2"}, true},
+		{"ipvlan on VM", clusterState{ClusterType: services.ClusterTypeECS, ContainerNetworkMode: "underlay_ipvlan"}, false},
+		{"ipvlan on BMS", clusterState{ClusterType: services.ClusterTypeBMS, ContainerNetworkMode: "underlay_ipvlan"}, true},
+		{"unknown", clusterState{ClusterType: services.ClusterTypeECS, ContainerNetworkMode: "flannel"}, false},
+		{"eni subnets without eni", clusterState{
+			ClusterType: services.ClusterTypeECS, ContainerNetworkMode: "vpc-router", EniSubnetIDs: []string{"s1"},
+		}, false},
+		{"eni", clusterState{
+			ClusterType: services.ClusterTypeECS, ClusterFlavor: "cce.s2.small", ContainerNetworkMode: "eni",
+			EniSubnetIDs: []string{"s1", "s2"}, EniSubnetCIDRs: []string{"10.1.0.0/16", "10.2.0.0/16"},
+		}, true},
+		{"eni without subnets", clusterState{
+			ClusterType: services.ClusterTypeECS, ClusterFlavor: "cce.s2.small", ContainerNetworkMode: "eni",
+		}, false},
+		{"eni on single master", clusterState{
+			ClusterType: services.ClusterTypeECS, ClusterFlavor: "cce.s1.small", ContainerNetworkMode: "eni",
+			EniSubnetIDs: []string{"s1"},
+		}, false},
+		{"eni on BMS", clusterState{
+			ClusterType: services.ClusterTypeBMS, ClusterFlavor: "cce.s2.small", ContainerNetworkMode: "eni",
+			EniSubnetIDs: []string{"s1"},
+		}, false},
+		{"eni CIDR count", clusterState{
+			ClusterType: services.ClusterTypeECS, ClusterFlavor: "cce.s2.small", ContainerNetworkMode: "eni",
+			EniSubnetIDs: []string{"s1", "s2"}, EniSubnetCIDRs: []string{"10.1.0.0/16"},
+		}, false},
+		{"eni invalid CIDR", clusterState{
+			ClusterType: services.ClusterTypeECS, ClusterFlavor: "cce.s2.small", ContainerNetworkMode: "eni",
+			EniSubnetIDs: []string{"s1"}, EniSubnetCIDRs: []string{"10.1.0.0"},
+		}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateContainerNetwork(&c.state)
+			if c.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestClusterCreateOpts(t *testing.T) {
+	state := &clusterState{
+		ClusterType:          services.ClusterTypeECS,
+		ClusterFlavor:        "cce.s2.small",
+		ContainerNetworkMode: "eni",
+		ContainerNetworkCidr: "172.16.0.0/16",
+		EniSubnetIDs:         []string{"s1", "s2"},
+		EniSubnetCIDRs:       []string{"10.1.0.0/16", "10.2.0.0/16"},
+	}
+	body, err := clusterCreateRequest(state).ToClusterCreateMap()
+	require.NoError(t, err)
+	spec := body["spec"].(map[string]interface{})
+	assert.Equal(t, "cce.s2.small", spec["flavor"])
+	assert.Equal(t, "Turbo", spec["category"])
+	assert.Equal(t, map[string]interface{}{"mode": "eni"}, spec["containerNetwork"])
+	assert.Equal(t, map[string]interface{}{
+		"eniSubnetId":   "s1;s2",
+		"eniSubnetCIDR": "10.1.0.0/16;10.2.0.0/16",
+		"subnets": []interface{}{
+			map[string]interface{}{"subnetID": "s1"},
+			map[string]interface{}{"subnetID": "s2"},
+		},
+	}, spec["eniNetwork"])
+
+	state.ContainerNetworkMode = "overlay_l2"
+	assert.Nil(t, eniNetwork(state))
+	body, err = clusterCreateRequest(state).ToClusterCreateMap()
+	require.NoError(t, err)
+	spec = body["spec"].(map[string]interface{})
+	assert.NotContains(t, spec, "category")
+	assert.NotContains(t, spec, "eniNetwork")
+}
+
+func TestDeleteNetwork(t *testing.T) {
+	deleted := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/test/vpcs/vpc-id/subnets/subnet-id":
+			deleted["subnet-id"] = true
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/test/vpcs/vpc-id":
+			deleted["vpc-id"] = true
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/v1/test/subnets/subnet-id" && !deleted["subnet-id"]:
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-id", "status": "ACTIVE"}}`))
+		case r.URL.Path == "/v1/test/vpcs/vpc-id" && !deleted["vpc-id"]:
+			_, _ = w.Write([]byte(`{"vpc": {"id": "vpc-id", "status": "OK"}}`))
+		case r.URL.Path == "/v1/test/subnets/stuck-id":
+			_, _ = w.Write([]byte(`{"subnet": {"id": "stuck-id", "status": "ACTIVE"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+	state := &clusterState{Timeouts: Timeouts{Network: 0}}
+	ctx := context.Background()
+
+	require.NoError(t, deleteSubnet(ctx, client, state, "vpc-id", "subnet-id"))
+	require.NoError(t, deleteVPC(ctx, client, state, "vpc-id"))
+	assert.Error(t, deleteSubnet(ctx, client, state, "vpc-id", "stuck-id"), "wait is limited by network timeout")
+}
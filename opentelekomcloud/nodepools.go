@@ -0,0 +1,439 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodepools"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nodePoolIDAnnotation = "kubernetes.io/node-pool.id"
+	nodePoolTypeVM       = "vm"
+	// nodePoolTypeBMS pools are groups of BMS nodes managed by the driver, CCE node pools support only ECS
+	nodePoolTypeBMS = "bms"
+	// nodePoolTypeLegacy pools are groups of nodes created without node pool by previous driver versions
+	nodePoolTypeLegacy = "legacy"
+	legacyNodePoolName = "legacy-nodes"
+)
+
+// nodePool describes single CCE node pool managed by the driver.
+// Options which are not set for the pool are taken from `clusterState.NodeConfig`
+type nodePool struct {
+	ID   string
+	Type string
+	Name string
+	// CCEName is the name of CCE node pool if it differs from the pool name, e.g. for the replacement pool
+	CCEName          string
+	FlavorID         string
+	AvailabilityZone string
+	RootVolume       nodes.VolumeSpec
+	DataVolumes      []nodes.VolumeSpec
+	Count            int
+	// Labels and Taints are set on the pool nodes in addition to cluster node labels and taints
+	Labels map[string]string
+	Taints []nodes.TaintSpec
+	// NodeIDs are IDs of BMS and legacy pool nodes
+	NodeIDs []string
+}
+
+func (p *nodePool) isBMS() bool {
+	return p.Type == nodePoolTypeBMS
+}
+
+// cceName returns the name of CCE node pool, pools are matched to update options by `Name`, which is kept
+// when nodes are replaced by the new CCE node pool
+func (p *nodePool) cceName() string {
+	if p.CCEName != "" {
+		return p.CCEName
+	}
+	return p.Name
+}
+
+// isNodeGroup returns true for pools which are not CCE node pools, their nodes are managed by the driver
+func (p *nodePool) isNodeGroup() bool {
+	return p.Type == nodePoolTypeBMS || p.Type == nodePoolTypeLegacy
+}
+
+// migrateLegacyNodes moves nodes created without node pool by previous driver versions to the legacy pool,
+// so they are scaled and deleted the same way as BMS nodes
+func migrateLegacyNodes(state *clusterState) {
+	if len(state.NodeIDs) == 0 {
+		return
+	}
+	config := state.NodeConfig
+	state.NodePools = append(state.NodePools, nodePool{
+		Type:             nodePoolTypeLegacy,
+		Name:             legacyNodePoolName,
+		FlavorID:         config.FlavorID,
+		AvailabilityZone: config.AvailabilityZone,
+		RootVolume:       config.RootVolume,
+		DataVolumes:      config.DataVolumes,
+		Count:            len(state.NodeIDs),
+		NodeIDs:          state.NodeIDs,
+	})
+	state.NodeIDs = nil
+}
+
+// parseNodePools parses `node-pools` option values in `key=value,key=value` format.
+// Single pool using `defaults` is returned if no values are provided.
+func parseNodePools(values []string, defaults nodePool) ([]nodePool, error) {
+	if len(values) == 0 {
+		pool := defaults
+		pool.Name = "pool-1"
+		return []nodePool{pool}, nil
+	}
+	pools := make([]nodePool, len(values))
+	names := map[string]bool{}
+	for i, value := range values {
+		pool := defaults
+		pool.Name = fmt.Sprintf("pool-%d", i+1)
+		pool.DataVolumes = append([]nodes.VolumeSpec{}, defaults.DataVolumes...)
+		for _, field := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid node pool parameter: %s", field)
+			}
+			key, val := kv[0], kv[1]
+			var err error
+			switch key {
+			case "name":
+				pool.Name = val
+			case "flavor":
+				pool.FlavorID = val
+			case "az":
+				pool.AvailabilityZone = val
+			case "count":
+				pool.Count, err = strconv.Atoi(val)
+			case "root-volume-size":
+				pool.RootVolume.Size, err = strconv.Atoi(val)
+			case "root-volume-type":
+				pool.RootVolume.VolumeType = val
+			case "data-volume-size":
+				pool.DataVolumes[0].Size, err = strconv.Atoi(val)
+			case "data-volume-type":
+				pool.DataVolumes[0].VolumeType = val
+			case "labels":
+				pool.Labels, err = parseLabels(strings.Split(val, ";"))
+			case "taints":
+				pool.Taints, err = parseTaints(strings.Split(val, ";"))
+			default:
+				return nil, fmt.Errorf("unknown node pool parameter: %s", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid node pool parameter %s: %s", key, err)
+			}
+		}
+		if names[pool.Name] {
+			return nil, fmt.Errorf("duplicate node pool name: %s", pool.Name)
+		}
+		names[pool.Name] = true
+		pools[i] = pool
+	}
+	return pools, nil
+}
+
+func totalNodeCount(pools []nodePool) int64 {
+	var count int64
+	for _, pool := range pools {
+		count += int64(pool.Count)
+	}
+	return count
+}
+
+// installScript encodes node installation script as required by CCE
+func installScript(script string) string {
+	if script == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(script))
+}
+
+// nodeTemplate builds node spec for the pool
+func nodeTemplate(state *clusterState, pool *nodePool) nodeSpec {
+	config := &state.NodeConfig
+	nodeOS := config.Os
+	if nodeOS == "" {
+		nodeOS = services.EulerOSVersion
+	}
+	spec := nodeSpec{
+		Spec: nodes.Spec{
+			Flavor:      pool.FlavorID,
+			Az:          pool.AvailabilityZone,
+			Os:          nodeOS,
+			Login:       nodes.LoginSpec{SshKey: config.KeyPair},
+			RootVolume:  pool.RootVolume,
+			DataVolumes: pool.DataVolumes,
+			BillingMode: config.BillingMode,
+			Count:       1,
+			K8sTags:     nodeLabels(state, pool),
+			Taints:      nodeTaintSpecs(state, pool),
+		},
+		ExtendParam: nodeExtendParam{
+			ExtendParam: nodes.ExtendParam{
+				ChargingMode:       config.ChargingMode,
+				EcsPerformanceType: config.PerformanceType,
+				MaxPods:            config.MaxPods,
+				OrderID:            config.OrderID,
+				ProductID:          config.ProductID,
+				PublicKey:          config.PublicKey,
+				PreInstall:         installScript(config.PreInstall),
+				PostInstall:        installScript(config.PostInstall),
+			},
+		},
+		PublicIP: nodeEipSpec(state),
+	}
+	if config.ChargingMode == chargingModePrepaid {
+		setNodePeriod(&spec.ExtendParam, &state.Period)
+	}
+	return spec
+}
+
+// createNodePool creates node pool and waits until all its nodes are active. `pool.ID` is updated inside
+func createNodePool(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool) error {
+	if pool.isNodeGroup() {
+		if len(pool.NodeIDs) > 0 {
+			if err := waitForNodesActive(ctx, client, state, pool.NodeIDs); err != nil {
+				return err
+			}
+		}
+		return scaleBMSNodes(ctx, client, state, pool, pool.Count)
+	}
+	if pool.ID != "" {
+		logrus.Infof("Waiting for existing node pool %s (%s) to become available", pool.Name, pool.ID)
+		return waitForNodePool(ctx, client, state, pool.ID, pool.Count)
+	}
+	created, err := nodepools.Create(client.CCE, state.ClusterID, nodePoolCreateOpts{
+		Kind:       "NodePool",
+		ApiVersion: "v3",
+		Metadata:   nodepools.CreateMetaData{Name: pool.cceName()},
+		Spec: nodePoolSpec{
+			Type:                 nodePoolTypeVM,
+			NodeTemplate:         nodeTemplate(state, pool),
+			InitialNodeCount:     pool.Count,
+			CustomSecurityGroups: nodeSecurityGroups(state),
+		},
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("failed to create node pool %s: %s", pool.Name, err)
+	}
+	pool.ID = created.Metadata.Id
+	logrus.Infof("Waiting for node pool %s (%s) to become available", pool.Name, pool.ID)
+	return waitForNodePool(ctx, client, state, pool.ID, pool.Count)
+}
+
+// scaleNodePool changes number of nodes in the pool and waits until the pool is scaled
+func scaleNodePool(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool, count int) error {
+	logrus.Infof("Scaling node pool %s from %d to %d nodes", pool.Name, pool.Count, count)
+	if pool.isNodeGroup() {
+		return scaleBMSNodes(ctx, client, state, pool, count)
+	}
+	if err := setNodePoolCount(client, state, pool, count); err != nil {
+		return fmt.Errorf("failed to scale node pool %s: %s", pool.Name, err)
+	}
+	if err := waitForNodePool(ctx, client, state, pool.ID, count); err != nil {
+		return err
+	}
+	pool.Count = count
+	return nil
+}
+
+// nodePoolScaleOpts returns node pool update changing only node count of the pool
+func nodePoolScaleOpts(pool *nodePool, count int) nodePoolUpdateOpts {
+	return nodePoolUpdateOpts{
+		Kind:       "NodePool",
+		ApiVersion: "v3",
+		Metadata:   nodepools.UpdateMetaData{Name: pool.cceName()},
+		Spec:       nodePoolUpdateSpec{InitialNodeCount: count},
+	}
+}
+
+// nodePoolTemplateOpts returns node pool update replacing labels and taints of the pool node template
+func nodePoolTemplateOpts(state *clusterState, pool *nodePool) nodePoolUpdateOpts {
+	opts := nodePoolScaleOpts(pool, pool.Count)
+	taints := nodeTaintSpecs(state, pool)
+	if taints == nil {
+		taints = []nodes.TaintSpec{}
+	}
+	opts.Spec.NodeTemplate = &nodeTemplateUpdate{
+		K8sTags: nodeLabels(state, pool),
+		Taints:  taints,
+	}
+	return opts
+}
+
+// setNodePoolCount sets node count of the pool, node template is kept
+func setNodePoolCount(client *services.Client, state *clusterState, pool *nodePool, count int) error {
+	return nodepools.Update(client.CCE, state.ClusterID, pool.ID, nodePoolScaleOpts(pool, count)).Err
+}
+
+// updateNodePoolTemplate updates labels and taints of the pool node template with current cluster and pool settings
+func updateNodePoolTemplate(client *services.Client, state *clusterState, pool *nodePool) error {
+	return nodepools.Update(client.CCE, state.ClusterID, pool.ID, nodePoolTemplateOpts(state, pool)).Err
+}
+
+// listNodePoolNodes returns all nodes belonging to the node pool
+func listNodePoolNodes(client *services.Client, clusterID, poolID string) ([]nodes.Nodes, error) {
+	nodeList, err := nodes.List(client.CCE, clusterID, nodes.ListOpts{})
+	if err != nil {
+		return nil, err
+	}
+	var result []nodes.Nodes
+	for _, node := range nodeList {
+		if node.Metadata.Annotations[nodePoolIDAnnotation] == poolID {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
+
+// waitForNodePool waits until node pool is synchronized and contains `count` active nodes,
+// jobs of the pool nodes being provisioned are tracked meanwhile
+func waitForNodePool(ctx context.Context, client *services.Client, state *clusterState, poolID string, count int) error {
+	clusterID := state.ClusterID
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		pool, err := nodepools.Get(client.CCE, clusterID, poolID).Extract()
+		if err != nil {
+			return true, err
+		}
+		if pool.Status.Phase == "Error" {
+			return true, fmt.Errorf("node pool %s is in error state", poolID)
+		}
+		poolNodes, err := listNodePoolNodes(client, clusterID, poolID)
+		if err != nil {
+			return true, err
+		}
+		active := 0
+		for _, node := range poolNodes {
+			addNodeJob(state, node.Status.JobID)
+			if node.Status.Phase == services.NodeActive {
+				active++
+				continue
+			}
+			trackJob(client, state, node.Status.JobID)
+		}
+		if pool.Status.Phase != "" || pool.Status.CurrentNode != count {
+			return false, nil
+		}
+		if active != count {
+			logrus.Debugf("Node pool %s has %d of %d active nodes", poolID, active, count)
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// deleteClusterNodes deletes nodes of all node pools, pools which failed to be created are skipped
+func deleteClusterNodes(ctx context.Context, client *services.Client, state *clusterState) error {
+	for i := range state.NodePools {
+		pool := &state.NodePools[i]
+		if !pool.isNodeGroup() && pool.ID == "" {
+			continue
+		}
+		if err := deleteNodePool(ctx, client, state, pool); err != nil {
+			return fmt.Errorf("failed to delete node pool %s: %s", pool.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteNodePool deletes node pool with all its nodes and waits until it is removed
+func deleteNodePool(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool) error {
+	clusterID := state.ClusterID
+	if pool.isNodeGroup() {
+		if len(pool.NodeIDs) == 0 {
+			return nil
+		}
+		return deleteNodes(ctx, client, state, pool.NodeIDs)
+	}
+	err := nodepools.Delete(client.CCE, clusterID, pool.ID).Err
+	if _, ok := err.(golangsdk.ErrDefault404); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Waiting for node pool %s to be deleted", pool.ID)
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		_, err := nodepools.Get(client.CCE, clusterID, pool.ID).Extract()
+		if err == nil {
+			return false, nil
+		}
+		if _, ok := err.(golangsdk.ErrDefault404); ok {
+			return true, nil
+		}
+		return true, err
+	})
+}
+
+func findNodePool(pools []nodePool, name string) *nodePool {
+	for i := range pools {
+		if pools[i].Name == name {
+			return &pools[i]
+		}
+	}
+	return nil
+}
+
+// resizeNodePools matches cluster node pools to `pools` from update options by name: pools missing in `pools`
+// are deleted except the legacy pool, pools with changed count are scaled and new pools are created
+func resizeNodePools(ctx context.Context, client *services.Client, state *clusterState, pools []nodePool) error {
+	var added []nodePool
+	for _, pool := range pools {
+		if findNodePool(state.NodePools, pool.Name) == nil {
+			added = append(added, pool)
+		}
+	}
+	if err := validateVolumes(&clusterState{NodePools: added}); err != nil {
+		return err
+	}
+	for _, pool := range added {
+		if isBMSFlavor(pool.FlavorID) != pool.isBMS() {
+			return fmt.Errorf("node flavor %s can't be used for node pool %s", pool.FlavorID, pool.Name)
+		}
+	}
+
+	var result []nodePool
+	for i := range state.NodePools {
+		pool := &state.NodePools[i]
+		if findNodePool(pools, pool.Name) != nil {
+			continue
+		}
+		if pool.Type == nodePoolTypeLegacy {
+			// nodes of previous driver versions are kept until they are scaled down explicitly
+			result = append(result, *pool)
+			continue
+		}
+		logrus.Infof("Deleting node pool %s", pool.Name)
+		if err := deleteNodePool(ctx, client, state, pool); err != nil {
+			return fmt.Errorf("failed to delete node pool %s: %s", pool.Name, err)
+		}
+	}
+	for _, update := range pools {
+		pool := findNodePool(state.NodePools, update.Name)
+		if pool == nil {
+			created := update
+			logrus.Infof("Creating node pool %s with %d nodes", created.Name, created.Count)
+			if err := createNodePool(ctx, client, state, &created); err != nil {
+				return err
+			}
+			result = append(result, created)
+			continue
+		}
+		if pool.Count != update.Count {
+			if err := scaleNodePool(ctx, client, state, pool, update.Count); err != nil {
+				return err
+			}
+		}
+		result = append(result, *pool)
+	}
+	state.NodePools = result
+	return syncNodeEips(client, state)
+}
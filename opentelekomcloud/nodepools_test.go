@@ -0,0 +1,227 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodePools(t *testing.T) {
+	defaults := nodePool{
+		FlavorID:         "s3.large.2",
+		AvailabilityZone: "eu-de-01",
+		RootVolume:       nodes.VolumeSpec{Size: 40, VolumeType: "SATA"},
+		DataVolumes:      []nodes.VolumeSpec{{Size: 100, VolumeType: "SATA"}},
+		Count:            2,
+	}
+
+	pools, err := parseNodePools(nil, defaults)
+	require.NoError(t, err)
+	require.Len(t, pools, 1)
+	assert.Equal(t, "pool-1", pools[0].Name)
+	assert.Equal(t, 2, pools[0].Count)
+
+	pools, err = parseNodePools([]string{
+		"name=general,count=3",
+		"flavor=s3.xlarge.4, az=eu-de-02,count=1,data-volume-size=200,data-volume-type=SSD",
+		"name=db,labels=role=db;tier=1,taints=dedicated=db:NoSchedule;maintenance:NoExecute",
+	}, defaults)
+	require.NoError(t, err)
+	require.Len(t, pools, 3)
+	assert.Equal(t, "general", pools[0].Name)
+	assert.Equal(t, "s3.large.2", pools[0].FlavorID)
+	assert.Equal(t, 3, pools[0].Count)
+	assert.Equal(t, 100, pools[0].DataVolumes[0].Size)
+	assert.Equal(t, "pool-2", pools[1].Name)
+	assert.Equal(t, "s3.xlarge.4", pools[1].FlavorID)
+	assert.Equal(t, "eu-de-02", pools[1].AvailabilityZone)
+	assert.Equal(t, nodes.VolumeSpec{Size: 200, VolumeType: "SSD"}, pools[1].DataVolumes[0])
+	assert.Equal(t, map[string]string{"role": "db", "tier": "1"}, pools[2].Labels)
+	assert.Equal(t, []nodes.TaintSpec{
+		{Key: "dedicated", Value: "db", Effect: "NoSchedule"},
+		{Key: "maintenance", Effect: "NoExecute"},
+	}, pools[2].Taints)
+	assert.Empty(t, pools[0].Labels)
+	assert.EqualValues(t, 6, totalNodeCount(pools))
+
+	_, err = parseNodePools([]string{"name=a", "name=a"}, defaults)
+	assert.Error(t, err)
+	_, err = parseNodePools([]string{"count=many"}, defaults)
+	assert.Error(t, err)
+	_, err = parseNodePools([]string{"gpu=1"}, defaults)
+	assert.Error(t, err)
+	_, err = parseNodePools([]string{"taints=dedicated=db"}, defaults)
+	assert.Error(t, err)
+}
+
+func TestNodeTemplate(t *testing.T) {
+	state := &clusterState{
+		NodeConfig: services.CreateNodesOpts{KeyPair: "key", ChargingMode: chargingModePrepaid, PreInstall: "echo pre"},
+		Period:     periodOpts{PeriodType: "month", PeriodNum: 2},
+	}
+	pool := &nodePool{Type: nodePoolTypeBMS, FlavorID: "physical.o2.medium", AvailabilityZone: "eu-de-01"}
+	body, err := nodeCreateOpts{Kind: "Node", Spec: nodeTemplate(state, pool)}.ToNodeCreateMap()
+	require.NoError(t, err)
+	spec := body["spec"].(map[string]interface{})
+	assert.Equal(t, "physical.o2.medium", spec["flavor"])
+	assert.Equal(t, services.EulerOSVersion, spec["os"])
+	assert.Equal(t, map[string]interface{}{
+		"chargingMode": float64(chargingModePrepaid),
+		"periodType":   "month",
+		"periodNum":    float64(2),
+		"isAutoPay":    true,
+		"isAutoRenew":  false,
+
+		"alpha.cce/preInstall": "ZWNobyBwcmU=",
+	}, spec["extendParam"])
+
+	state.NodeConfig.ChargingMode = 0
+	pool.Type = nodePoolTypeVM
+	extendParam := nodeTemplate(state, pool).ExtendParam
+	assert.Empty(t, extendParam.PeriodType)
+	assert.Nil(t, extendParam.IsAutoPay)
+}
+
+func TestNodePoolUpdateOpts(t *testing.T) {
+	pool := &nodePool{Name: "general", Count: 2}
+
+	body, err := nodePoolScaleOpts(pool, 3).ToNodePoolUpdateMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"initialNodeCount": float64(3)}, body["spec"], "only node count is sent on scale")
+
+	body, err = nodePoolTemplateOpts(&clusterState{}, pool).ToNodePoolUpdateMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"initialNodeCount": float64(2),
+		"nodeTemplate": map[string]interface{}{
+			"k8sTags": map[string]interface{}{},
+			"taints":  []interface{}{},
+		},
+	}, body["spec"], "removed labels and taints are sent as empty values")
+
+	replaced := &nodePool{Name: "general", CCEName: "general-r1"}
+	body, err = nodePoolScaleOpts(replaced, 3).ToNodePoolUpdateMap()
+	require.NoError(t, err)
+	assert.Equal(t, "general-r1", body["metadata"].(map[string]interface{})["name"])
+}
+
+func TestMigrateLegacyNodes(t *testing.T) {
+	state := &clusterState{
+		NodeConfig: services.CreateNodesOpts{FlavorID: "s3.large.2", AvailabilityZone: "eu-de-01"},
+		NodeIDs:    []string{"node-1", "node-2"},
+	}
+	migrateLegacyNodes(state)
+	assert.Empty(t, state.NodeIDs)
+	require.Len(t, state.NodePools, 1)
+	pool := state.NodePools[0]
+	assert.Equal(t, nodePoolTypeLegacy, pool.Type)
+	assert.Equal(t, "s3.large.2", pool.FlavorID)
+	assert.Equal(t, 2, pool.Count)
+	assert.Equal(t, []string{"node-1", "node-2"}, pool.NodeIDs)
+	assert.True(t, pool.isNodeGroup())
+
+	migrateLegacyNodes(state)
+	assert.Len(t, state.NodePools, 1, "migration is done once")
+}
+
+// fakeNodePools serves CCE node pools API, pools are scaled immediately
+func fakeNodePools(t *testing.T, counts map[string]int, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3/projects/test/clusters/cluster-id")
+		if r.Method != http.MethodGet {
+			*requests = append(*requests, r.Method+" "+path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body := struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				InitialNodeCount int `json:"initialNodeCount"`
+			} `json:"spec"`
+		}{}
+		if r.Body != nil {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+		}
+		poolID := strings.TrimPrefix(path, "/nodepools/")
+		switch {
+		case path == "/nodes":
+			var items []string
+			for id, count := range counts {
+				for i := 0; i < count; i++ {
+					items = append(items, fmt.Sprintf(
+						`{"metadata": {"uid": "%s-%d", "annotations": {%q: %q}}, "status": {"phase": "Active"}}`,
+						id, i, nodePoolIDAnnotation, id))
+				}
+			}
+			_, _ = fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+			return
+		case path == "/nodepools" && r.Method == http.MethodPost:
+			poolID = body.Metadata.Name + "-id"
+			counts[poolID] = body.Spec.InitialNodeCount
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			counts[poolID] = body.Spec.InitialNodeCount
+		case r.Method == http.MethodDelete:
+			delete(counts, poolID)
+			_, _ = fmt.Fprint(w, `{}`)
+			return
+		}
+		count, ok := counts[poolID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{}`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"metadata": {"uid": %q}, "spec": {"initialNodeCount": %d}, "status": {"currentNode": %d}}`,
+			poolID, count, count)
+	}))
+}
+
+func TestResizeNodePools(t *testing.T) {
+	counts := map[string]int{"a-id": 1, "b-id": 2}
+	var requests []string
+	server := fakeNodePools(t, counts, &requests)
+	defer server.Close()
+
+	volumes := nodePool{
+		Type:        nodePoolTypeVM,
+		RootVolume:  nodes.VolumeSpec{Size: 40, VolumeType: "SATA"},
+		DataVolumes: []nodes.VolumeSpec{{Size: 100, VolumeType: "SATA"}},
+	}
+	pool := func(name, id string, count int) nodePool {
+		p := volumes
+		p.Name, p.ID, p.Count = name, id, count
+		return p
+	}
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		NodePools: []nodePool{pool("a", "a-id", 1), pool("b", "b-id", 2)},
+		Timeouts:  Timeouts{Nodes: 60},
+	}
+	err := resizeNodePools(context.Background(), fakeCCEClient(server.URL), state, []nodePool{
+		pool("a", "", 2), pool("c", "", 1),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DELETE /nodepools/b-id", "PUT /nodepools/a-id", "POST /nodepools"}, requests)
+	require.Len(t, state.NodePools, 2)
+	assert.Equal(t, "a", state.NodePools[0].Name)
+	assert.Equal(t, 2, state.NodePools[0].Count)
+	assert.Equal(t, "c", state.NodePools[1].Name)
+	assert.Equal(t, "c-id", state.NodePools[1].ID)
+	assert.Equal(t, map[string]int{"a-id": 2, "c-id": 1}, counts)
+
+	err = resizeNodePools(context.Background(), fakeCCEClient(server.URL), state, []nodePool{
+		pool("a", "", 2), {Name: "d", Type: nodePoolTypeVM, Count: 1},
+	})
+	assert.Error(t, err, "new pools are validated")
+}
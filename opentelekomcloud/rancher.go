@@ -28,9 +28,32 @@ const (
 	serviceAccountSecretAnnotation = "kubernetes.io/service-account.name"
 )
 
+// retryServiceAccountToken generates service account token retrying failed attempts with growing delay
+func retryServiceAccountToken(ctx context.Context, clientset kubernetes.Interface, timeouts Timeouts) (string, error) {
+	for retry := 0; ; retry++ {
+		token, err := generateServiceAccountToken(ctx, clientset)
+		if err == nil {
+			logrus.Info("service account token generated successfully")
+			return token, nil
+		}
+		logrus.WithError(err).Warnf("error creating service account")
+		if retry >= timeouts.Retries {
+			logrus.Error("retries exceeded, failing post-check")
+			return "", err
+		}
+		delay := timeouts.retryDelay(retry)
+		logrus.Infof("service account token generation failed, retries left: %v, next retry in %v", timeouts.Retries-retry, delay)
+		select {
+		case <-ctx.Done():
+			return "", cancelledError(ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
 // GenerateServiceAccountToken generate a serviceAccountToken for clusterAdmin given a rest clientset
-func generateServiceAccountToken(clientset kubernetes.Interface) (string, error) {
-	_, err := clientset.CoreV1().Namespaces().Create(context.TODO(), &v1.Namespace{
+func generateServiceAccountToken(ctx context.Context, clientset kubernetes.Interface) (string, error) {
+	_, err := clientset.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: cattleNamespace,
 		},
@@ -45,7 +68,7 @@ func generateServiceAccountToken(clientset kubernetes.Interface) (string, error)
 		},
 	}
 
-	_, err = clientset.CoreV1().ServiceAccounts(cattleNamespace).Create(context.TODO(), serviceAccount, metav1.CreateOptions{})
+	_, err = clientset.CoreV1().ServiceAccounts(cattleNamespace).Create(ctx, serviceAccount, metav1.CreateOptions{})
 	if err != nil && !errors.IsAlreadyExists(err) {
 		return "", fmt.Errorf("error creating service account: %v", err)
 	}
@@ -66,9 +89,9 @@ func generateServiceAccountToken(clientset kubernetes.Interface) (string, error)
 			},
 		},
 	}
-	clusterAdminRole, err := clientset.RbacV1().ClusterRoles().Get(context.TODO(), clusterAdmin, metav1.GetOptions{})
+	clusterAdminRole, err := clientset.RbacV1().ClusterRoles().Get(ctx, clusterAdmin, metav1.GetOptions{})
 	if err != nil {
-		clusterAdminRole, err = clientset.RbacV1().ClusterRoles().Create(context.TODO(), adminRole, metav1.CreateOptions{})
+		clusterAdminRole, err = clientset.RbacV1().ClusterRoles().Create(ctx, adminRole, metav1.CreateOptions{})
 		if err != nil {
 			return "", fmt.Errorf("error creating admin role: %v", err)
 		}
@@ -92,14 +115,14 @@ func generateServiceAccountToken(clientset kubernetes.Interface) (string, error)
 			APIGroup: rbacv1.GroupName,
 		},
 	}
-	if _, err = clientset.RbacV1().ClusterRoleBindings().Create(context.TODO(), clusterRoleBinding, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+	if _, err = clientset.RbacV1().ClusterRoleBindings().Create(ctx, clusterRoleBinding, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
 		return "", fmt.Errorf("error creating role bindings: %v", err)
 	}
 
-	if serviceAccount, err = clientset.CoreV1().ServiceAccounts(cattleNamespace).Get(context.Background(), serviceAccount.Name, metav1.GetOptions{}); err != nil {
+	if serviceAccount, err = clientset.CoreV1().ServiceAccounts(cattleNamespace).Get(ctx, serviceAccount.Name, metav1.GetOptions{}); err != nil {
 		return "", fmt.Errorf("error getting service account: %w", err)
 	}
-	secret, err := ensureSecretForServiceAccount(context.Background(), nil, clientset, serviceAccount)
+	secret, err := ensureSecretForServiceAccount(ctx, nil, clientset, serviceAccount)
 	if err != nil {
 		return "", fmt.Errorf("error ensuring secret for service account: %w", err)
 	}
@@ -156,7 +179,7 @@ func ensureSecretForServiceAccount(ctx context.Context, secretsCache corecontrol
 		Cap:      100 * time.Millisecond,
 		Steps:    50,
 	}
-	err = wait.ExponentialBackoff(backoff, func() (bool, error) {
+	err = wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
 		var err error
 		// use the secret client, rather than the secret getter, to circumvent the cache
 		secret, err = secretClient.Get(ctx, secret.Name, metav1.GetOptions{})
@@ -0,0 +1,216 @@
+package opentelekomcloud
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/obs"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// restoreOrder lists resources which have to be restored before all other ones
+var restoreOrder = []string{
+	"customresourcedefinitions.apiextensions.k8s.io",
+	"namespaces",
+	"serviceaccounts",
+	"clusterroles.rbac.authorization.k8s.io",
+	"clusterrolebindings.rbac.authorization.k8s.io",
+	"roles.rbac.authorization.k8s.io",
+	"rolebindings.rbac.authorization.k8s.io",
+	"storageclasses.storage.k8s.io",
+	"persistentvolumes",
+	"persistentvolumeclaims",
+	"secrets",
+	"configmaps",
+}
+
+// retry creation while CRDs and namespaces are not yet available
+var createBackoff = wait.Backoff{
+	Duration: 1 * time.Second,
+	Factor:   2,
+	Steps:    6,
+}
+
+func restorePriority(entry snapshotEntry) int {
+	fullName := entry.Resource
+	if entry.Group != "" {
+		fullName += "." + entry.Group
+	}
+	for i, name := range restoreOrder {
+		if name == fullName {
+			return i
+		}
+	}
+	return len(restoreOrder)
+}
+
+// sortedEntries returns snapshot entries in the order they have to be restored
+func sortedEntries(objects map[string][]byte) ([]snapshotEntry, error) {
+	entries := make([]snapshotEntry, 0, len(objects))
+	for p := range objects {
+		entry, err := parseSnapshotPath(p)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		pi, pj := restorePriority(entries[i]), restorePriority(entries[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return entries[i].path() < entries[j].path()
+	})
+	return entries, nil
+}
+
+// readSnapshot reads objects from gzipped tar archive
+func readSnapshot(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	objects := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		objects[header.Name] = data
+	}
+}
+
+// downloadSnapshot loads snapshot objects from the OBS bucket
+func downloadSnapshot(backup *backupOpts, key string) (map[string][]byte, error) {
+	client, err := newOBSClient(backup)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	input := &obs.GetObjectInput{}
+	input.Bucket = backup.Bucket
+	input.Key = key
+	output, err := client.GetObject(input)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return readSnapshot(output.Body)
+}
+
+// prepareObject returns object data adjusted for restore. Restored PVCs get new UIDs, so UID and resource version
+// of PV claim reference are cleared to let the PV bind to the restored claim
+func prepareObject(entry snapshotEntry, data []byte) ([]byte, error) {
+	if entry.Group != "" || entry.Resource != "persistentvolumes" {
+		return data, nil
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(obj.Object, "spec", "claimRef", "uid")
+	unstructured.RemoveNestedField(obj.Object, "spec", "claimRef", "resourceVersion")
+	return obj.MarshalJSON()
+}
+
+// applyObject creates the object, existing objects are updated
+func applyObject(ctx context.Context, restClient rest.Interface, entry snapshotEntry, data []byte) error {
+	err := wait.ExponentialBackoff(createBackoff, func() (bool, error) {
+		err := restClient.Post().
+			AbsPath(entry.resourcePath(true)).
+			SetHeader("Content-Type", "application/json").
+			Body(data).
+			Do(ctx).Error()
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return true, err
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("resource is not available")
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	objectPath := path.Join(entry.resourcePath(true), entry.Name)
+	raw, err := restClient.Get().AbsPath(objectPath).Do(ctx).Raw()
+	if err != nil {
+		return err
+	}
+	existing := &unstructured.Unstructured{}
+	if err := existing.UnmarshalJSON(raw); err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	updated, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return restClient.Put().
+		AbsPath(objectPath).
+		SetHeader("Content-Type", "application/json").
+		Body(updated).
+		Do(ctx).Error()
+}
+
+// restoreSnapshot downloads snapshot from OBS and applies all its objects to the cluster
+func restoreSnapshot(ctx context.Context, clientSet kubernetes.Interface, backup *backupOpts, clusterID, snapshotName string) error {
+	key := snapshotKey(clusterID, snapshotName)
+	objects, err := downloadSnapshot(backup, key)
+	if isOBSNotFound(err) {
+		return fmt.Errorf("snapshot %s/%s doesn't exist, it may be removed by retention policy", backup.Bucket, key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download snapshot %s/%s: %s", backup.Bucket, key, err)
+	}
+	entries, err := sortedEntries(objects)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Restoring %d objects from snapshot %s", len(entries), snapshotName)
+
+	restClient := clientSet.Discovery().RESTClient()
+	var errs []error
+	for _, entry := range entries {
+		data, err := prepareObject(entry, objects[entry.path()])
+		if err == nil {
+			err = applyObject(ctx, restClient, entry, data)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to restore %s: %s", entry.path(), err)
+			logrus.WithError(err).Warn("object is not restored")
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d objects are not restored: %w", len(errs), len(entries), errors.Join(errs...))
+	}
+	logrus.Infof("Snapshot %s restored", snapshotName)
+	return nil
+}
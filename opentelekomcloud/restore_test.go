@@ -0,0 +1,122 @@
+package opentelekomcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_ETCDRestore(t *testing.T) {
+	storage, obsServer := newFakeOBS(t)
+	defer obsServer.Close()
+
+	snapshot := map[string][]byte{
+		"apps/v1/deployments/apps/web.json":                                 []byte(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "apps"}}`),
+		"example.com/v1/widgets/apps/w.json":                                []byte(`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w", "namespace": "apps"}}`),
+		"rbac.authorization.k8s.io/v1/roles/apps/reader.json":               []byte(`{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "Role", "metadata": {"name": "reader", "namespace": "apps"}}`),
+		"core/v1/namespaces/_cluster/apps.json":                             []byte(`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "apps"}}`),
+		"apiextensions.k8s.io/v1/customresourcedefinitions/_cluster/w.json": []byte(`{"apiVersion": "apiextensions.k8s.io/v1", "kind": "CustomResourceDefinition", "metadata": {"name": "w"}}`),
+		"core/v1/configmaps/apps/broken.json":                               []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "broken", "namespace": "apps"}}`),
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeSnapshot(buf, snapshot))
+	storage.objects["cluster-id/snapshot-1.tar.gz"] = buf.Bytes()
+
+	var lock sync.Mutex
+	var requests []string
+	widgetAttempts := 0
+	writeStatus := func(w http.ResponseWriter, code int, reason string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = fmt.Fprintf(w, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": %q, "code": %d}`, reason, code)
+	}
+	kubeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v1/namespaces":
+			writeStatus(w, http.StatusConflict, "AlreadyExists")
+		case "GET /api/v1/namespaces/apps":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "apps", "resourceVersion": "5"}}`)
+		case "PUT /api/v1/namespaces/apps":
+			obj := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(body, &obj))
+			assert.Equal(t, "5", obj["metadata"].(map[string]interface{})["resourceVersion"])
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(body)
+		case "POST /apis/example.com/v1/namespaces/apps/widgets":
+			widgetAttempts++
+			if widgetAttempts == 1 {
+				writeStatus(w, http.StatusNotFound, "NotFound")
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		case "POST /api/v1/namespaces/apps/configmaps":
+			writeStatus(w, http.StatusForbidden, "Forbidden")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		}
+	}))
+	defer kubeServer.Close()
+
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		Backup:    backupOpts{Bucket: testBucket, Endpoint: obsServer.URL, AccessKey: "ak", SecretKey: "sk"},
+	}
+	info, err := stateToInfo(state, &types.ClusterInfo{Endpoint: kubeServer.URL})
+	require.NoError(t, err)
+
+	driver := NewDriver()
+	_, err = driver.ETCDRestore(context.Background(), info, &types.DriverOptions{}, "snapshot-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 6 objects are not restored")
+	assert.Contains(t, err.Error(), "core/v1/configmaps/apps/broken.json")
+
+	assert.Equal(t, []string{
+		"POST /apis/apiextensions.k8s.io/v1/customresourcedefinitions",
+		"POST /api/v1/namespaces",
+		"GET /api/v1/namespaces/apps",
+		"PUT /api/v1/namespaces/apps",
+		"POST /apis/rbac.authorization.k8s.io/v1/namespaces/apps/roles",
+		"POST /api/v1/namespaces/apps/configmaps",
+		"POST /apis/apps/v1/namespaces/apps/deployments",
+		"POST /apis/example.com/v1/namespaces/apps/widgets",
+		"POST /apis/example.com/v1/namespaces/apps/widgets",
+	}, requests)
+
+	_, err = driver.ETCDRestore(context.Background(), info, &types.DriverOptions{}, "missing")
+	assert.Error(t, err)
+}
+
+func TestPrepareObject(t *testing.T) {
+	pv := []byte(`{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv-1"}, "spec": {"claimRef": {
+		"kind": "PersistentVolumeClaim", "namespace": "apps", "name": "data", "uid": "old-uid", "resourceVersion": "42"}}}`)
+	data, err := prepareObject(snapshotEntry{Resource: "persistentvolumes", Version: "v1", Name: "pv-1"}, pv)
+	require.NoError(t, err)
+	obj := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &obj))
+	assert.Equal(t, map[string]interface{}{
+		"kind": "PersistentVolumeClaim", "namespace": "apps", "name": "data",
+	}, obj["spec"].(map[string]interface{})["claimRef"])
+
+	cm := []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cm", "uid": "uid"}}`)
+	data, err = prepareObject(snapshotEntry{Resource: "configmaps", Version: "v1", Namespace: "apps", Name: "cm"}, cm)
+	require.NoError(t, err)
+	assert.Equal(t, cm, data, "other objects are not changed")
+}
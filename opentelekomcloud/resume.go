@@ -0,0 +1,192 @@
+package opentelekomcloud
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodepools"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/common/tags"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// managedByLabel marks CCE clusters created by the driver
+	managedByLabel = "managed-by"
+	managedByValue = "kontainer-engine"
+	// creationTokenLabel holds the token generated by Create, only clusters with the token of the same
+	// Rancher cluster are adopted. Unlike managedByLabel it can't be guessed by cluster-labels
+	creationTokenLabel = "kontainer-engine-creation-token"
+
+	// clusterTagKey is the tag of VPCs and subnets created by the driver, its value is the cluster name
+	clusterTagKey = "kontainer-engine-cluster"
+
+	resourceTypeVpc    = "vpcs"
+	resourceTypeSubnet = "subnets"
+)
+
+// cceClusterLabels returns labels of CCE cluster including labels marking the cluster as created by the driver,
+// labels set by the driver can't be overridden
+func cceClusterLabels(labels map[string]string, token string) map[string]string {
+	result := map[string]string{}
+	for key, value := range labels {
+		result[key] = value
+	}
+	result[managedByLabel] = managedByValue
+	if token != "" {
+		result[creationTokenLabel] = token
+	}
+	return result
+}
+
+// creationToken returns the token of previous Create attempt saved by Rancher in the info of failed cluster,
+// new token is generated for the first attempt
+func creationToken(info *types.ClusterInfo) (string, error) {
+	if info.Metadata["state"] != "" {
+		if previous, err := infoToState(info); err == nil && previous.CreationToken != "" {
+			return previous.CreationToken, nil
+		}
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate creation token: %s", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// findCluster returns CCE cluster with the given name, nil is returned if there is no such cluster
+func findCluster(client *services.Client, name string) (*clusters.Clusters, error) {
+	clusterList, err := clusters.List(client.CCE, clusters.ListOpts{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusterList {
+		if cluster.Metadata.Name == name {
+			return &cluster, nil
+		}
+	}
+	return nil, nil
+}
+
+// adoptCluster makes Create continue with the cluster left by previous Create attempt instead of creating new one.
+// Only the cluster with the creation token of this attempt is adopted, so clusters of other Rancher clusters
+// with the same name are never removed by rollback. Network of the cluster was set up by that attempt
+// and is reused, see adoptNetwork
+func adoptCluster(state *clusterState, cluster *clusters.Clusters) error {
+	labels := cluster.Metadata.Labels
+	if labels[managedByLabel] != managedByValue || state.CreationToken == "" ||
+		labels[creationTokenLabel] != state.CreationToken {
+		return fmt.Errorf("cluster %s already exists and was not created by previous attempt", cluster.Metadata.Name)
+	}
+	if cluster.Status.Phase == clusterPhaseDeleting {
+		return fmt.Errorf("cluster %s already exists and is being deleted", cluster.Metadata.Name)
+	}
+	logrus.WithFields(logrus.Fields{
+		"cluster": cluster.Metadata.Id,
+		"phase":   cluster.Status.Phase,
+		"job":     cluster.Status.JobID,
+	}).Infof("Cluster %s already exists, resuming creation", cluster.Metadata.Name)
+	state.ClusterID = cluster.Metadata.Id
+	state.ClusterJobID = cluster.Status.JobID
+	state.NodeConfig.ClusterID = state.ClusterID
+	state.VpcID = cluster.Spec.HostNetwork.VpcId
+	state.SubnetID = cluster.Spec.HostNetwork.SubnetId
+	state.HighwaySubnetID = cluster.Spec.HostNetwork.HighwaySubnet
+	state.ClusterFloatingIP = cluster.Spec.ExtendParam["clusterExternalIP"]
+	state.ManagedResources.Cluster = true
+	return nil
+}
+
+// tagNetworkResource marks VPC or subnet as created by the driver for the cluster
+func tagNetworkResource(client *services.Client, state *clusterState, resourceType, id string) error {
+	tag := tags.ResourceTag{Key: clusterTagKey, Value: state.ClusterName}
+	if err := tags.Create(client.NetworkV2, resourceType, id, []tags.ResourceTag{tag}).Err; err != nil {
+		return fmt.Errorf("failed to tag %s %s: %s", resourceType, id, err)
+	}
+	return nil
+}
+
+// adoptNetworkResource marks existing VPC or subnet as managed if it was created by the driver for the cluster
+func adoptNetworkResource(client *services.Client, state *clusterState, managed *bool, resourceType, id string) error {
+	resourceTags, err := tags.Get(client.NetworkV2, resourceType, id).Extract()
+	if err != nil {
+		return fmt.Errorf("failed to get tags of %s %s: %s", resourceType, id, err)
+	}
+	for _, tag := range resourceTags {
+		if tag.Key == clusterTagKey && tag.Value == state.ClusterName {
+			logrus.Infof("Using %s %s created by previous attempt", resourceType, id)
+			*managed = true
+		}
+	}
+	return nil
+}
+
+// adoptNetwork restores managed resources of the adopted cluster network, so resources created
+// by previous Create attempt are removed together with the cluster. NAT gateway is adopted by createNatGateway
+func adoptNetwork(client *services.Client, state *clusterState) error {
+	resources := &state.ManagedResources
+	if err := adoptNetworkResource(client, state, &resources.Vpc, resourceTypeVpc, state.VpcID); err != nil {
+		return err
+	}
+	if err := adoptNetworkResource(client, state, &resources.Subnet, resourceTypeSubnet, state.SubnetID); err != nil {
+		return err
+	}
+	if state.HighwaySubnetID != "" {
+		if err := adoptNetworkResource(client, state, &resources.HighwaySubnet, resourceTypeSubnet, state.HighwaySubnetID); err != nil {
+			return err
+		}
+	}
+	eip, err := findNamedEip(client, clusterEipName(state))
+	if err != nil {
+		return err
+	}
+	if eip != nil && (state.ClusterFloatingIP == "" || eip.PublicipAddress == state.ClusterFloatingIP) {
+		logrus.Infof("Using cluster EIP %s created by previous attempt", eip.PublicipAddress)
+		state.ClusterFloatingIP = eip.PublicipAddress
+		resources.ClusterEip = true
+	}
+	return nil
+}
+
+// adoptNodePools fills IDs of node pools and BMS nodes created by previous Create attempt
+func adoptNodePools(client *services.Client, state *clusterState) error {
+	poolList, err := nodepools.List(client.CCE, state.ClusterID, nodepools.ListOpts{})
+	if err != nil {
+		return fmt.Errorf("failed to list node pools: %s", err)
+	}
+	nodeList, err := nodes.List(client.CCE, state.ClusterID, nodes.ListOpts{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %s", err)
+	}
+	for i := range state.NodePools {
+		pool := &state.NodePools[i]
+		if pool.isNodeGroup() {
+			pool.NodeIDs = nil
+			for _, node := range nodeList {
+				if node.Metadata.Annotations[nodePoolIDAnnotation] != "" {
+					continue
+				}
+				name := node.Metadata.Name
+				if name == pool.Name || strings.HasPrefix(name, pool.Name+"-") {
+					pool.NodeIDs = append(pool.NodeIDs, node.Metadata.Id)
+				}
+			}
+			if len(pool.NodeIDs) > 0 {
+				logrus.Infof("Found %d existing nodes of pool %s", len(pool.NodeIDs), pool.Name)
+			}
+			continue
+		}
+		for _, existing := range poolList {
+			if existing.Metadata.Name == pool.cceName() {
+				logrus.Infof("Found existing node pool %s (%s)", pool.cceName(), existing.Metadata.Id)
+				pool.ID = existing.Metadata.Id
+			}
+		}
+	}
+	return nil
+}
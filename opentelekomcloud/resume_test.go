@@ -0,0 +1,199 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	golangsdk "github.com/opentelekomcloud/gophertelekomcloud"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/clusters"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCCEClusterLabels(t *testing.T) {
+	assert.Equal(t, map[string]string{managedByLabel: managedByValue}, cceClusterLabels(nil, ""))
+	assert.Equal(t,
+		map[string]string{managedByLabel: managedByValue, creationTokenLabel: "token", "env": "prod"},
+		cceClusterLabels(map[string]string{"env": "prod", creationTokenLabel: "guess"}, "token"),
+		"labels of the driver can't be overridden",
+	)
+}
+
+func TestCreationToken(t *testing.T) {
+	info := &types.ClusterInfo{}
+	token, err := creationToken(info)
+	require.NoError(t, err)
+	assert.Len(t, token, 16)
+	other, err := creationToken(info)
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	_, err = stateToInfo(&clusterState{CreationToken: token}, info)
+	require.NoError(t, err)
+	resumed, err := creationToken(info)
+	require.NoError(t, err)
+	assert.Equal(t, token, resumed, "token of previous attempt is reused")
+}
+
+func TestFindCluster(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/projects/test/clusters", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"items": [
+			{"metadata": {"name": "other", "uid": "other-id"}},
+			{"metadata": {"name": "test", "uid": "cluster-id"}, "status": {"phase": "Creating", "jobID": "job-id"}}
+		]}`)
+	}))
+	defer server.Close()
+	client := fakeCCEClient(server.URL)
+
+	cluster, err := findCluster(client, "test")
+	require.NoError(t, err)
+	require.NotNil(t, cluster)
+	assert.Equal(t, "cluster-id", cluster.Metadata.Id)
+
+	cluster, err = findCluster(client, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, cluster)
+}
+
+func TestAdoptCluster(t *testing.T) {
+	cluster := &clusters.Clusters{
+		Metadata: clusters.MetaData{Name: "test", Id: "cluster-id"},
+		Spec: clusters.Spec{
+			HostNetwork: clusters.HostNetworkSpec{VpcId: "vpc-id", SubnetId: "subnet-id"},
+			ExtendParam: map[string]string{"clusterExternalIP": "80.158.1.1"},
+		},
+		Status: clusters.Status{Phase: "Creating", JobID: "job-id"},
+	}
+	state := &clusterState{ClusterName: "test", CreationToken: "token"}
+	assert.Error(t, adoptCluster(state, cluster), "cluster without managed-by label can't be adopted")
+	assert.Empty(t, state.ClusterID)
+
+	cluster.Metadata.Labels = map[string]string{managedByLabel: managedByValue}
+	assert.Error(t, adoptCluster(state, cluster), "cluster with managed-by label set by cluster-labels isn't adopted")
+	cluster.Metadata.Labels = cceClusterLabels(nil, "other-token")
+	assert.Error(t, adoptCluster(state, cluster), "cluster of another Create isn't adopted")
+	assert.False(t, state.ManagedResources.Cluster)
+
+	cluster.Metadata.Labels = cceClusterLabels(nil, "token")
+	require.NoError(t, adoptCluster(state, cluster))
+	assert.Equal(t, "cluster-id", state.ClusterID)
+	assert.Equal(t, "cluster-id", state.NodeConfig.ClusterID)
+	assert.Equal(t, "job-id", state.ClusterJobID)
+	assert.Equal(t, "vpc-id", state.VpcID)
+	assert.Equal(t, "subnet-id", state.SubnetID)
+	assert.Equal(t, "80.158.1.1", state.ClusterFloatingIP)
+	assert.True(t, state.ManagedResources.Cluster)
+
+	cluster.Status.Phase = clusterPhaseDeleting
+	assert.Error(t, adoptCluster(&clusterState{CreationToken: "token"}, cluster))
+}
+
+func TestAdoptNodePools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v3/projects/test/clusters/cluster-id/nodepools":
+			_, _ = fmt.Fprint(w, `{"items": [{"metadata": {"name": "pool-1", "uid": "pool-1-id"}}]}`)
+		case "/api/v3/projects/test/clusters/cluster-id/nodes":
+			_, _ = fmt.Fprint(w, `{"items": [
+				{"metadata": {"name": "bms-1", "uid": "node-1"}},
+				{"metadata": {"name": "bms-1-abcde", "uid": "node-2"}},
+				{"metadata": {"name": "bms-10", "uid": "node-3"}},
+				{"metadata": {"name": "bms-1-fghij", "uid": "node-4", "annotations": {"kubernetes.io/node-pool.id": "pool-1-id"}}}
+			]}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	state := &clusterState{
+		ClusterID: "cluster-id",
+		NodePools: []nodePool{
+			{Name: "pool-1", Type: nodePoolTypeVM},
+			{Name: "pool-2", Type: nodePoolTypeVM},
+			{Name: "bms-1", Type: nodePoolTypeBMS},
+		},
+	}
+	require.NoError(t, adoptNodePools(fakeCCEClient(server.URL), state))
+	assert.Equal(t, "pool-1-id", state.NodePools[0].ID)
+	assert.Empty(t, state.NodePools[1].ID)
+	assert.Equal(t, []string{"node-1", "node-2"}, state.NodePools[2].NodeIDs)
+}
+
+// fakeNetworkClient returns client with CCE, VPC and networking services served by `url`, project ID is "test"
+func fakeNetworkClient(url string) *services.Client {
+	provider := &golangsdk.ProviderClient{HTTPClient: http.Client{}, ProjectID: "test"}
+	client := fakeCCEClient(url)
+	client.VPC = &golangsdk.ServiceClient{ProviderClient: provider, Endpoint: url + "/", ResourceBase: url + "/v1/"}
+	client.NetworkV2 = &golangsdk.ServiceClient{ProviderClient: provider, Endpoint: url + "/", ResourceBase: url + "/v2.0/"}
+	return client
+}
+
+func fakeNetwork(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2.0/test/vpcs/vpc-id/tags":
+			_, _ = fmt.Fprintf(w, `{"tags": [{"key": %q, "value": "test"}]}`, clusterTagKey)
+		case "/v2.0/test/subnets/subnet-id/tags":
+			_, _ = fmt.Fprintf(w, `{"tags": [{"key": %q, "value": "other"}]}`, clusterTagKey)
+		case "/v1/test/bandwidths":
+			_, _ = fmt.Fprint(w, `{"bandwidths": [
+				{"name": "other-cluster-eip", "publicip_info": [{"publicip_id": "other-id", "publicip_address": "80.158.1.2"}]},
+				{"name": "test-cluster-eip", "publicip_info": [{"publicip_id": "eip-id", "publicip_address": "80.158.1.1"}]},
+				{"name": "test-nat-eip", "publicip_info": [{"publicip_id": "nat-eip-id", "publicip_address": "80.158.1.3"}]}
+			]}`)
+		case "/v2.0/nat_gateways":
+			assert.Equal(t, "test-nat", r.URL.Query().Get("name"))
+			assert.Equal(t, "vpc-id", r.URL.Query().Get("router_id"))
+			_, _ = fmt.Fprint(w, `{"nat_gateways": [{"id": "nat-id", "name": "test-nat"}]}`)
+		case "/v2.0/snat_rules":
+			assert.Equal(t, "nat-id", r.URL.Query().Get("nat_gateway_id"))
+			_, _ = fmt.Fprint(w, `{"snat_rules": [
+				{"id": "other-rule-id", "network_id": "other-subnet-id"},
+				{"id": "rule-id", "network_id": "subnet-id"}
+			]}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestAdoptNetwork(t *testing.T) {
+	server := fakeNetwork(t)
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+
+	state := &clusterState{ClusterName: "test", VpcID: "vpc-id", SubnetID: "subnet-id"}
+	require.NoError(t, adoptNetwork(client, state))
+	assert.True(t, state.ManagedResources.Vpc, "VPC is tagged with the cluster name")
+	assert.False(t, state.ManagedResources.Subnet, "subnet is tagged with other cluster name")
+	assert.True(t, state.ManagedResources.ClusterEip)
+	assert.Equal(t, "80.158.1.1", state.ClusterFloatingIP)
+
+	state = &clusterState{ClusterName: "test", VpcID: "vpc-id", SubnetID: "subnet-id", ClusterFloatingIP: "80.158.1.10"}
+	require.NoError(t, adoptNetwork(client, state))
+	assert.False(t, state.ManagedResources.ClusterEip, "cluster uses EIP not created by the driver")
+	assert.Equal(t, "80.158.1.10", state.ClusterFloatingIP)
+}
+
+func TestAdoptNatGateway(t *testing.T) {
+	server := fakeNetwork(t)
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+
+	state := &clusterState{ClusterName: "test", VpcID: "vpc-id", SubnetID: "subnet-id"}
+	require.NoError(t, adoptNatGateway(client, client.NetworkV2, state))
+	assert.Equal(t, "nat-id", state.NatGatewayID)
+	assert.Equal(t, "nat-eip-id", state.NatEipID)
+	assert.Equal(t, "rule-id", state.SnatRuleID)
+	resources := state.ManagedResources
+	assert.True(t, resources.NatGateway && resources.NatEip && resources.SnatRule)
+}
@@ -0,0 +1,463 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodepools"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultRollingSurge          = 1
+	defaultRollingMaxUnavailable = 0
+
+	// replacementSuffix is added to the name of node pool replacing the pool with outdated node configuration
+	replacementSuffix = "-r"
+
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+// rollingUpdate limits node count during replacement of nodes with outdated configuration
+type rollingUpdate struct {
+	// Surge is the number of nodes which can be created above the pool node count
+	Surge int
+	// MaxUnavailable is the number of pool nodes which can be drained before replacement nodes are ready
+	MaxUnavailable int
+}
+
+func defaultRollingUpdate() rollingUpdate {
+	return rollingUpdate{Surge: defaultRollingSurge, MaxUnavailable: defaultRollingMaxUnavailable}
+}
+
+// isSet returns false if neither surge nor max-unavailable is set
+func (r rollingUpdate) isSet() bool {
+	return r.Surge != 0 || r.MaxUnavailable != 0
+}
+
+func (r rollingUpdate) validate() error {
+	if r.Surge < 0 || r.MaxUnavailable < 0 {
+		return fmt.Errorf("rolling-surge and rolling-max-unavailable can't be negative")
+	}
+	if !r.isSet() {
+		return fmt.Errorf("one of rolling-surge and rolling-max-unavailable should be positive")
+	}
+	return nil
+}
+
+// step returns number of nodes to add to the replacement pool and number of old nodes to remove during the
+// next step of replacing `count` nodes, where `replaced` nodes are already created and `old` nodes are left.
+// Pool never has more than count+Surge nodes and less than count-MaxUnavailable ready nodes
+func (r rollingUpdate) step(count, replaced, old int) (add, remove int) {
+	add = count - replaced
+	if limit := count + r.Surge - replaced - old; limit < add {
+		add = limit
+	}
+	if add < 0 {
+		add = 0
+	}
+	remove = replaced + add + old - (count - r.MaxUnavailable)
+	if remove > old {
+		remove = old
+	}
+	if remove < 0 {
+		remove = 0
+	}
+	return add, remove
+}
+
+// mergeVolume returns `current` volume with size, type and encryption set in `update`
+func mergeVolume(current, update nodes.VolumeSpec) nodes.VolumeSpec {
+	if update.Size > 0 {
+		current.Size = update.Size
+	}
+	if update.VolumeType != "" {
+		current.VolumeType = update.VolumeType
+	}
+	if update.Metadata != nil {
+		current.Metadata = update.Metadata
+	}
+	return current
+}
+
+// desiredNodePool returns `pool` with node configuration from update options, options which are not set are kept
+func desiredNodePool(pool nodePool, update nodePool) nodePool {
+	desired := pool
+	if update.FlavorID != "" {
+		desired.FlavorID = update.FlavorID
+	}
+	desired.RootVolume = mergeVolume(pool.RootVolume, update.RootVolume)
+	if len(update.DataVolumes) == 1 && reflect.DeepEqual(update.DataVolumes[0], nodes.VolumeSpec{}) {
+		return desired
+	}
+	desired.DataVolumes = make([]nodes.VolumeSpec, len(update.DataVolumes))
+	for i, volume := range update.DataVolumes {
+		current := nodes.VolumeSpec{}
+		if i < len(pool.DataVolumes) {
+			current = pool.DataVolumes[i]
+		} else if len(pool.DataVolumes) > 0 {
+			current = nodes.VolumeSpec{Size: pool.DataVolumes[0].Size, VolumeType: pool.DataVolumes[0].VolumeType}
+		}
+		desired.DataVolumes[i] = mergeVolume(current, volume)
+	}
+	return desired
+}
+
+// nodeConfigChanged returns true if nodes of the pool have to be replaced to apply `desired` configuration
+func nodeConfigChanged(pool, desired nodePool) bool {
+	return pool.FlavorID != desired.FlavorID ||
+		!reflect.DeepEqual(pool.RootVolume, desired.RootVolume) ||
+		!reflect.DeepEqual(pool.DataVolumes, desired.DataVolumes)
+}
+
+// findUpdatedPool returns pool from update options matching the cluster pool by name. Single pool is matched to
+// single cluster pool regardless of the name, as pools created without `node-pools` option have default names
+func findUpdatedPool(pool nodePool, pools []nodePool, single bool) (nodePool, bool) {
+	for _, updated := range pools {
+		if updated.Name == pool.Name {
+			return updated, true
+		}
+	}
+	if single && len(pools) == 1 {
+		return pools[0], true
+	}
+	return nodePool{}, false
+}
+
+// replacementPoolName returns the name of node pool replacing the pool, e.g. `pool-1-r1` for `pool-1`
+// and `pool-1-r2` for `pool-1-r1`
+func replacementPoolName(name string) string {
+	if i := strings.LastIndex(name, replacementSuffix); i > 0 {
+		if revision, err := strconv.Atoi(name[i+len(replacementSuffix):]); err == nil && revision > 0 {
+			return fmt.Sprintf("%s%s%d", name[:i], replacementSuffix, revision+1)
+		}
+	}
+	return name + replacementSuffix + "1"
+}
+
+// planNodeReplacement returns node pools with node configuration from update options and indexes of pools
+// which nodes have to be replaced. Nodes of all pools are replaced if node OS is changed. Nodes of legacy pool
+// can't be replaced, changes of their configuration are rejected
+func planNodeReplacement(state, newState *clusterState) ([]nodePool, []int, error) {
+	osChanged := newState.NodeConfig.Os != "" && newState.NodeConfig.Os != state.NodeConfig.Os
+	desired := make([]nodePool, len(state.NodePools))
+	var replaced []int
+	for i, pool := range state.NodePools {
+		desired[i] = pool
+		if update, ok := findUpdatedPool(pool, newState.NodePools, len(state.NodePools) == 1); ok {
+			desired[i] = desiredNodePool(pool, update)
+		}
+		if pool.Type == nodePoolTypeLegacy && newState.NodeConfig.FlavorID != "" {
+			// legacy nodes are created with node-flavor option
+			desired[i].FlavorID = newState.NodeConfig.FlavorID
+		}
+		if isBMSFlavor(desired[i].FlavorID) != pool.isBMS() {
+			return nil, nil, fmt.Errorf("node flavor %s can't be used for node pool %s", desired[i].FlavorID, pool.Name)
+		}
+		if !osChanged && !nodeConfigChanged(pool, desired[i]) {
+			continue
+		}
+		if pool.Type == nodePoolTypeLegacy {
+			return nil, nil, fmt.Errorf("node configuration of pool %s can't be changed: its nodes are created "+
+				"without node pool by previous driver version", pool.Name)
+		}
+		replaced = append(replaced, i)
+	}
+	if len(replaced) == 0 {
+		return desired, nil, nil
+	}
+	if err := validateVolumes(&clusterState{NodePools: desired}); err != nil {
+		return nil, nil, err
+	}
+	if err := state.RollingUpdate.validate(); err != nil {
+		return nil, nil, err
+	}
+	return desired, replaced, nil
+}
+
+// replaceNodes replaces nodes of `replaced` pools with nodes using `desired` pool configuration
+func replaceNodes(ctx context.Context, client *services.Client, clientSet kubernetes.Interface, state *clusterState, desired []nodePool, replaced []int) error {
+	for _, i := range replaced {
+		if err := replaceNodePool(ctx, client, clientSet, state, &state.NodePools[i], desired[i]); err != nil {
+			return fmt.Errorf("failed to replace nodes of pool %s: %w", state.NodePools[i].Name, err)
+		}
+	}
+	return syncNodeEips(client, state)
+}
+
+// replaceNodePool replaces nodes of the pool with nodes using `desired` configuration. New VM nodes are created in
+// the replacement node pool, BMS nodes are created in the same pool. Old nodes are drained and deleted in steps
+// limited by the rolling update settings
+func replaceNodePool(ctx context.Context, client *services.Client, clientSet kubernetes.Interface, state *clusterState, pool *nodePool, desired nodePool) error {
+	count := pool.Count
+	replacement := desired
+	replacement.ID = ""
+	replacement.NodeIDs = nil
+	replacement.Count = 0
+	if !pool.isNodeGroup() {
+		replacement.CCEName = replacementPoolName(pool.cceName())
+		if err := createReplacementPool(ctx, client, state, &replacement); err != nil {
+			return err
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"pool":           pool.Name,
+		"replacement":    replacement.cceName(),
+		"surge":          state.RollingUpdate.Surge,
+		"maxUnavailable": state.RollingUpdate.MaxUnavailable,
+	}).Infof("Replacing %d nodes", count)
+
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		oldNodes, err := poolNodes(client, state, pool)
+		if err != nil {
+			return err
+		}
+		newNodes, err := poolNodes(client, state, &replacement)
+		if err != nil {
+			return err
+		}
+		add, remove := state.RollingUpdate.step(count, len(newNodes), len(oldNodes))
+		if add == 0 && remove == 0 {
+			break
+		}
+		if add > 0 {
+			if err := scaleNodePool(ctx, client, state, &replacement, len(newNodes)+add); err != nil {
+				return err
+			}
+			if newNodes, err = poolNodes(client, state, &replacement); err != nil {
+				return err
+			}
+			if err := waitForKubeNodesReady(ctx, clientSet, state, kubeNodeNames(newNodes)); err != nil {
+				return err
+			}
+		}
+		if remove > 0 {
+			if err := removePoolNodes(ctx, client, clientSet, state, pool, oldNodes[:remove]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !pool.isNodeGroup() {
+		if err := deleteNodePool(ctx, client, state, pool); err != nil {
+			return fmt.Errorf("failed to delete node pool %s: %s", pool.Name, err)
+		}
+	}
+	replacement.Count = count
+	*pool = replacement
+	logrus.Infof("Nodes of pool %s are replaced", pool.Name)
+	return nil
+}
+
+// createReplacementPool creates empty node pool for replacement nodes, the pool created by previous failed
+// update is reused
+func createReplacementPool(ctx context.Context, client *services.Client, state *clusterState, pool *nodePool) error {
+	poolList, err := nodepools.List(client.CCE, state.ClusterID, nodepools.ListOpts{})
+	if err != nil {
+		return fmt.Errorf("failed to list node pools: %s", err)
+	}
+	for _, existing := range poolList {
+		if existing.Metadata.Name == pool.cceName() {
+			logrus.Infof("Found existing node pool %s (%s)", pool.cceName(), existing.Metadata.Id)
+			pool.ID = existing.Metadata.Id
+			pool.Count = existing.Spec.InitialNodeCount
+		}
+	}
+	return createNodePool(ctx, client, state, pool)
+}
+
+// poolNodes returns CCE nodes of the node pool or BMS nodes of the pool
+func poolNodes(client *services.Client, state *clusterState, pool *nodePool) ([]nodes.Nodes, error) {
+	if !pool.isNodeGroup() {
+		poolNodes, err := listNodePoolNodes(client, state.ClusterID, pool.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes of pool %s: %s", pool.Name, err)
+		}
+		return poolNodes, nil
+	}
+	result := make([]nodes.Nodes, 0, len(pool.NodeIDs))
+	for _, nodeID := range pool.NodeIDs {
+		node, err := nodes.Get(client.CCE, state.ClusterID, nodeID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %s", nodeID, err)
+		}
+		result = append(result, *node)
+	}
+	return result, nil
+}
+
+// kubeNodeNames returns Kubernetes node names of CCE nodes, CCE nodes are registered by private IP
+func kubeNodeNames(cceNodes []nodes.Nodes) []string {
+	names := make([]string, len(cceNodes))
+	for i, node := range cceNodes {
+		names[i] = node.Status.PrivateIP
+	}
+	return names
+}
+
+// removePoolNodes drains and deletes nodes of the pool, node count of the pool is decreased accordingly
+func removePoolNodes(ctx context.Context, client *services.Client, clientSet kubernetes.Interface, state *clusterState, pool *nodePool, removed []nodes.Nodes) error {
+	nodeIDs := make([]string, len(removed))
+	for i, node := range removed {
+		nodeIDs[i] = node.Metadata.Id
+		if err := drainNode(ctx, clientSet, state, node.Status.PrivateIP); err != nil {
+			return err
+		}
+	}
+	if err := deleteNodes(ctx, client, state, nodeIDs); err != nil {
+		return err
+	}
+	if pool.isNodeGroup() {
+		var left []string
+		for _, nodeID := range pool.NodeIDs {
+			if !containsString(nodeIDs, nodeID) {
+				left = append(left, nodeID)
+			}
+		}
+		pool.NodeIDs = left
+		pool.Count = len(left)
+		return nil
+	}
+	poolNodes, err := listNodePoolNodes(client, state.ClusterID, pool.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes of pool %s: %s", pool.Name, err)
+	}
+	// pool count is decreased, so deleted nodes are not created again
+	if err := setNodePoolCount(client, state, pool, len(poolNodes)); err != nil {
+		return fmt.Errorf("failed to scale node pool %s: %s", pool.Name, err)
+	}
+	pool.Count = len(poolNodes)
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// waitForKubeNodesReady waits until all given nodes are registered in Kubernetes and ready
+func waitForKubeNodesReady(ctx context.Context, clientSet kubernetes.Interface, state *clusterState, names []string) error {
+	logrus.Infof("Waiting for nodes %v to become ready", names)
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		for _, name := range names {
+			node, err := clientSet.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				logrus.Debugf("Node %s is not registered yet", name)
+				return false, nil
+			}
+			if err != nil {
+				return true, fmt.Errorf("failed to get node %s: %s", name, err)
+			}
+			if !isNodeReady(node) {
+				logrus.Debugf("Node %s is not ready", name)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// cordonNode marks the node unschedulable
+func cordonNode(ctx context.Context, clientSet kubernetes.Interface, name string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": true},
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Cordoning node %s", name)
+	_, err = clientSet.CoreV1().Nodes().Patch(ctx, name, k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cordon node %s: %s", name, err)
+	}
+	return nil
+}
+
+// isEvictable returns false for pods which are not evicted during drain: DaemonSet pods, mirror pods and
+// finished pods
+func isEvictable(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// drainNode cordons the node and evicts its pods waiting until they are gone. Evictions blocked by
+// pod disruption budgets are retried until the nodes timeout
+func drainNode(ctx context.Context, clientSet kubernetes.Interface, state *clusterState, name string) error {
+	if err := cordonNode(ctx, clientSet, name); err != nil {
+		return err
+	}
+	logrus.Infof("Draining node %s", name)
+	return waitFor(ctx, state.Timeouts.Nodes, state.Timeouts.PollInterval, func() (bool, error) {
+		podList, err := clientSet.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + name})
+		if err != nil {
+			return true, fmt.Errorf("failed to list pods of node %s: %s", name, err)
+		}
+		left := 0
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if pod.Spec.NodeName != name || !isEvictable(pod) {
+				continue
+			}
+			left++
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			err := clientSet.CoreV1().Pods(pod.Namespace).EvictV1(ctx, &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			switch {
+			case err == nil, apierrors.IsNotFound(err):
+			case apierrors.IsTooManyRequests(err):
+				logrus.Debugf("Eviction of pod %s/%s is blocked by disruption budget", pod.Namespace, pod.Name)
+			default:
+				return true, fmt.Errorf("failed to evict pod %s/%s: %s", pod.Namespace, pod.Name, err)
+			}
+		}
+		if left > 0 {
+			logrus.Debugf("Node %s has %d pods left", name, left)
+			return false, nil
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,204 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRollingUpdateStep(t *testing.T) {
+	cases := map[string]struct {
+		update rollingUpdate
+		steps  [][2]int
+	}{
+		"surge": {
+			update: rollingUpdate{Surge: 1},
+			steps:  [][2]int{{1, 1}, {1, 1}, {1, 1}, {0, 0}},
+		},
+		"max-unavailable": {
+			update: rollingUpdate{MaxUnavailable: 1},
+			steps:  [][2]int{{0, 1}, {1, 1}, {1, 1}, {1, 0}, {0, 0}},
+		},
+		"surge and max-unavailable": {
+			update: rollingUpdate{Surge: 2, MaxUnavailable: 1},
+			steps:  [][2]int{{2, 3}, {1, 0}, {0, 0}},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			count, replaced, old := 3, 0, 3
+			var steps [][2]int
+			for i := 0; i < 10; i++ {
+				add, remove := c.update.step(count, replaced, old)
+				steps = append(steps, [2]int{add, remove})
+				if add == 0 && remove == 0 {
+					break
+				}
+				assert.LessOrEqual(t, replaced+add+old, count+c.update.Surge)
+				assert.GreaterOrEqual(t, replaced+add+old-remove, count-c.update.MaxUnavailable)
+				replaced, old = replaced+add, old-remove
+			}
+			assert.Equal(t, c.steps, steps)
+			assert.Equal(t, count, replaced)
+			assert.Zero(t, old)
+		})
+	}
+
+	assert.Error(t, rollingUpdate{}.validate())
+	assert.Error(t, rollingUpdate{Surge: 1, MaxUnavailable: -1}.validate())
+	assert.NoError(t, defaultRollingUpdate().validate())
+}
+
+func TestReplacementPoolName(t *testing.T) {
+	assert.Equal(t, "pool-1-r1", replacementPoolName("pool-1"))
+	assert.Equal(t, "pool-1-r2", replacementPoolName("pool-1-r1"))
+	assert.Equal(t, "pool-r-r1", replacementPoolName("pool-r"))
+}
+
+func TestPlanNodeReplacement(t *testing.T) {
+	pool := nodePool{
+		Name:        "general",
+		Type:        nodePoolTypeVM,
+		FlavorID:    "s3.large.2",
+		RootVolume:  nodes.VolumeSpec{Size: 40, VolumeType: "SATA"},
+		DataVolumes: []nodes.VolumeSpec{{Size: 100, VolumeType: "SATA"}},
+		Count:       2,
+	}
+	state := &clusterState{
+		NodeConfig:    services.CreateNodesOpts{Os: "EulerOS 2.9"},
+		NodePools:     []nodePool{pool},
+		RollingUpdate: defaultRollingUpdate(),
+	}
+
+	unset := &clusterState{NodePools: []nodePool{{Name: "pool-1", DataVolumes: []nodes.VolumeSpec{{}}}}}
+	_, replaced, err := planNodeReplacement(state, unset)
+	require.NoError(t, err)
+	assert.Empty(t, replaced, "options which are not set don't change nodes")
+
+	flavor := &clusterState{NodePools: []nodePool{{
+		Name:        "pool-1",
+		FlavorID:    "s3.xlarge.4",
+		DataVolumes: []nodes.VolumeSpec{{Size: 200}, {VolumeType: "SSD"}},
+	}}}
+	desired, replaced, err := planNodeReplacement(state, flavor)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, replaced)
+	assert.Equal(t, "general", desired[0].Name)
+	assert.Equal(t, "s3.xlarge.4", desired[0].FlavorID)
+	assert.Equal(t, pool.RootVolume, desired[0].RootVolume)
+	assert.Equal(t, []nodes.VolumeSpec{{Size: 200, VolumeType: "SATA"}, {Size: 100, VolumeType: "SSD"}}, desired[0].DataVolumes)
+	assert.Equal(t, "s3.large.2", state.NodePools[0].FlavorID, "cluster state is not changed")
+
+	nodeOS := &clusterState{NodeConfig: services.CreateNodesOpts{Os: "Ubuntu 22.04"}}
+	_, replaced, err = planNodeReplacement(state, nodeOS)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, replaced)
+
+	_, _, err = planNodeReplacement(state, &clusterState{NodePools: []nodePool{{Name: "general", FlavorID: "physical.o2.medium"}}})
+	assert.Error(t, err, "VM pool can't use BMS flavor")
+	_, _, err = planNodeReplacement(state, &clusterState{NodePools: []nodePool{{Name: "general", RootVolume: nodes.VolumeSpec{Size: 10}}}})
+	assert.Error(t, err)
+
+	replacedPool := pool
+	replacedPool.CCEName = "general-r1"
+	otherPool := pool
+	otherPool.Name = "other"
+	replacedPools := &clusterState{
+		NodePools:     []nodePool{replacedPool, otherPool},
+		RollingUpdate: defaultRollingUpdate(),
+	}
+	desired, replaced, err = planNodeReplacement(replacedPools, &clusterState{NodePools: []nodePool{
+		{Name: "general", FlavorID: "s3.xlarge.4", DataVolumes: []nodes.VolumeSpec{{}}},
+		{Name: "other", DataVolumes: []nodes.VolumeSpec{{}}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, replaced, "replaced pool is matched by its name")
+	assert.Equal(t, "general-r1", desired[0].cceName())
+}
+
+func TestPlanNodeReplacementLegacy(t *testing.T) {
+	state := &clusterState{
+		NodeConfig: services.CreateNodesOpts{FlavorID: "s3.large.2", Os: "EulerOS 2.9"},
+		NodeIDs:    []string{"node-1"},
+		NodePools:  []nodePool{{Name: "pool-1", Type: nodePoolTypeVM, FlavorID: "s3.large.2"}},
+	}
+	migrateLegacyNodes(state)
+
+	_, replaced, err := planNodeReplacement(state, &clusterState{NodeConfig: services.CreateNodesOpts{FlavorID: "s3.large.2"}})
+	require.NoError(t, err)
+	assert.Empty(t, replaced)
+
+	_, _, err = planNodeReplacement(state, &clusterState{NodeConfig: services.CreateNodesOpts{FlavorID: "s3.xlarge.4"}})
+	assert.Error(t, err, "legacy nodes can't be replaced")
+	_, _, err = planNodeReplacement(state, &clusterState{NodeConfig: services.CreateNodesOpts{Os: "Ubuntu 22.04"}})
+	assert.Error(t, err, "legacy nodes can't be replaced")
+}
+
+func readyNode(name string, ready v1.ConditionStatus) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}}},
+	}
+}
+
+func TestWaitForKubeNodesReady(t *testing.T) {
+	state := &clusterState{Timeouts: Timeouts{Nodes: 0, PollInterval: 1}}
+	clientSet := fake.NewSimpleClientset(readyNode("192.168.0.10", v1.ConditionTrue), readyNode("192.168.0.11", v1.ConditionFalse))
+	ctx := context.Background()
+	assert.NoError(t, waitForKubeNodesReady(ctx, clientSet, state, []string{"192.168.0.10"}))
+	assert.Error(t, waitForKubeNodesReady(ctx, clientSet, state, []string{"192.168.0.10", "192.168.0.11"}))
+	assert.Error(t, waitForKubeNodesReady(ctx, clientSet, state, []string{"192.168.0.12"}))
+}
+
+func TestDrainNode(t *testing.T) {
+	pod := func(name, node string, mutate func(*v1.Pod)) *v1.Pod {
+		p := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: node},
+		}
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+	clientSet := fake.NewSimpleClientset(
+		readyNode("old", v1.ConditionTrue),
+		pod("app", "old", nil),
+		pod("other-node", "new", nil),
+		pod("daemon", "old", func(p *v1.Pod) {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent"}}
+		}),
+		pod("mirror", "old", func(p *v1.Pod) {
+			p.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+		}),
+	)
+	var evicted []string
+	clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		evicted = append(evicted, eviction.Name)
+		return true, nil, clientSet.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+	state := &clusterState{Timeouts: Timeouts{Nodes: 60, PollInterval: 1}}
+
+	require.NoError(t, drainNode(context.Background(), clientSet, state, "old"))
+	assert.Equal(t, []string{"app"}, evicted)
+	node, err := clientSet.CoreV1().Nodes().Get(context.Background(), "old", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+	pods, err := clientSet.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, pods.Items, 3, "DaemonSet, mirror and other node pods are kept")
+}
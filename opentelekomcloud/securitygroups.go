@@ -0,0 +1,188 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/networking/v2/extensions/security/rules"
+	"github.com/sirupsen/logrus"
+)
+
+var securityGroupProtocols = []string{"tcp", "udp"}
+
+// securityGroupRule is ingress rule of the security group managed by the driver
+type securityGroupRule struct {
+	Protocol string
+	PortMin  int
+	PortMax  int
+	CIDR     string
+}
+
+// parseSecurityGroupRules parses rules in `protocol:port[-port]:cidr` format
+func parseSecurityGroupRules(values []string) ([]securityGroupRule, error) {
+	var result []securityGroupRule
+	for _, value := range values {
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid security group rule %s, should be in protocol:port[-port]:cidr format", value)
+		}
+		rule := securityGroupRule{Protocol: strings.ToLower(parts[0]), CIDR: parts[2]}
+		if rule.Protocol != securityGroupProtocols[0] && rule.Protocol != securityGroupProtocols[1] {
+			return nil, fmt.Errorf("invalid security group rule %s, protocol should be one of %s",
+				value, strings.Join(securityGroupProtocols, ", "))
+		}
+		from, to, isRange := strings.Cut(parts[1], "-")
+		if !isRange {
+			to = from
+		}
+		var err error
+		if rule.PortMin, err = strconv.Atoi(from); err != nil {
+			return nil, fmt.Errorf("invalid security group rule %s: %s", value, err)
+		}
+		if rule.PortMax, err = strconv.Atoi(to); err != nil {
+			return nil, fmt.Errorf("invalid security group rule %s: %s", value, err)
+		}
+		if rule.PortMin < 1 || rule.PortMax > 65535 || rule.PortMin > rule.PortMax {
+			return nil, fmt.Errorf("invalid security group rule %s, invalid port range", value)
+		}
+		if _, _, err := net.ParseCIDR(rule.CIDR); err != nil {
+			return nil, fmt.Errorf("invalid security group rule %s: %s", value, err)
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// validateSecurityGroups checks that security groups are used only with node pools
+func validateSecurityGroups(state *clusterState) error {
+	if len(state.SecurityGroups) == 0 && len(state.SecurityGroupRules) == 0 {
+		return nil
+	}
+	if state.ClusterType == services.ClusterTypeBMS {
+		return fmt.Errorf("custom security groups are not supported by %s clusters", services.ClusterTypeBMS)
+	}
+	return nil
+}
+
+// securityGroupDescription marks the node security group as created by the driver for the cluster,
+// security groups don't support resource tags, so the marker is kept in the description
+func securityGroupDescription(state *clusterState) string {
+	return fmt.Sprintf("Node security group of CCE cluster %s (%s=%s)", state.ClusterName, clusterTagKey, state.ClusterName)
+}
+
+// setupSecurityGroups finds IDs of existing security groups and creates managed security group if rules are set
+func setupSecurityGroups(client *services.Client, state *clusterState) error {
+	if len(state.SecurityGroups) > 0 && len(state.SecurityGroupIDs) == 0 {
+		ids, err := client.FindSecurityGroups(append([]string{}, state.SecurityGroups...))
+		if err != nil {
+			return err
+		}
+		state.SecurityGroupIDs = ids
+	}
+	if len(state.SecurityGroupRules) == 0 || state.SecurityGroupID != "" {
+		return nil
+	}
+	name := state.ClusterName + "-nodes"
+	description := securityGroupDescription(state)
+	// the group can be left by previous Create attempt, groups with the same name not created by the driver are ignored
+	pages, err := groups.List(client.NetworkV2, groups.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return fmt.Errorf("failed to list security groups: %s", err)
+	}
+	existing, err := groups.ExtractGroups(pages)
+	if err != nil {
+		return err
+	}
+	for _, group := range existing {
+		if group.Name == name && group.Description == description {
+			logrus.Infof("Using security group %s created by previous attempt", group.ID)
+			state.SecurityGroupID = group.ID
+			state.ManagedResources.SecurityGroup = true
+			break
+		}
+	}
+	if state.SecurityGroupID == "" {
+		group, err := groups.Create(client.NetworkV2, groups.CreateOpts{
+			Name:        name,
+			Description: description,
+		}).Extract()
+		if err != nil {
+			return fmt.Errorf("failed to create security group: %s", err)
+		}
+		logrus.Infof("Created security group %s", group.ID)
+		state.SecurityGroupID = group.ID
+		state.ManagedResources.SecurityGroup = true
+	}
+	return createSecurityGroupRules(client, state)
+}
+
+// createSecurityGroupRules creates rules of the managed security group missing in it,
+// so rules are complete in the group left by previous Create attempt
+func createSecurityGroupRules(client *services.Client, state *clusterState) error {
+	pages, err := rules.List(client.NetworkV2, rules.ListOpts{
+		SecGroupID: state.SecurityGroupID,
+		Direction:  string(rules.DirIngress),
+	}).AllPages()
+	if err != nil {
+		return fmt.Errorf("failed to list security group rules: %s", err)
+	}
+	existing, err := rules.ExtractRules(pages)
+	if err != nil {
+		return err
+	}
+	present := map[securityGroupRule]bool{}
+	for _, rule := range existing {
+		present[securityGroupRule{
+			Protocol: rule.Protocol,
+			PortMin:  rule.PortRangeMin,
+			PortMax:  rule.PortRangeMax,
+			CIDR:     rule.RemoteIPPrefix,
+		}] = true
+	}
+	for _, rule := range state.SecurityGroupRules {
+		if present[rule] {
+			continue
+		}
+		err := rules.Create(client.NetworkV2, rules.CreateOpts{
+			Direction:      rules.DirIngress,
+			EtherType:      rules.EtherType4,
+			SecGroupID:     state.SecurityGroupID,
+			PortRangeMin:   rule.PortMin,
+			PortRangeMax:   rule.PortMax,
+			Protocol:       rules.RuleProtocol(rule.Protocol),
+			RemoteIPPrefix: rule.CIDR,
+		}).Err
+		if err != nil {
+			return fmt.Errorf("failed to create security group rule: %s", err)
+		}
+	}
+	return nil
+}
+
+// nodeSecurityGroups returns IDs of custom security groups attached to nodes
+func nodeSecurityGroups(state *clusterState) []string {
+	ids := append([]string{}, state.SecurityGroupIDs...)
+	if state.SecurityGroupID != "" {
+		ids = append(ids, state.SecurityGroupID)
+	}
+	return ids
+}
+
+// deleteSecurityGroup deletes the security group and waits until it is removed, missing group is not an error
+func deleteSecurityGroup(ctx context.Context, client *services.Client, state *clusterState, id string) error {
+	if err := groups.Delete(client.NetworkV2, id).Err; err != nil && !isNotFound(err) {
+		return err
+	}
+	return waitFor(ctx, state.Timeouts.Network, networkPollInterval, func() (bool, error) {
+		_, err := groups.Get(client.NetworkV2, id).Extract()
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err != nil, err
+	})
+}
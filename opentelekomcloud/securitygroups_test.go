@@ -0,0 +1,125 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentelekomcloud-infra/crutch-house/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecurityGroupRules(t *testing.T) {
+	rules, err := parseSecurityGroupRules([]string{"tcp:30000-32767:192.0.2.0/24", "UDP:53:10.0.0.0/8"})
+	require.NoError(t, err)
+	assert.Equal(t, []securityGroupRule{
+		{Protocol: "tcp", PortMin: 30000, PortMax: 32767, CIDR: "192.0.2.0/24"},
+		{Protocol: "udp", PortMin: 53, PortMax: 53, CIDR: "10.0.0.0/8"},
+	}, rules)
+
+	for _, value := range []string{
+		"tcp:22", "icmp:0:10.0.0.0/8", "tcp:ssh:10.0.0.0/8", "tcp:443-80:10.0.0.0/8", "tcp:0:10.0.0.0/8", "tcp:22:office",
+	} {
+		_, err := parseSecurityGroupRules([]string{value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNodeSecurityGroups(t *testing.T) {
+	state := &clusterState{ClusterType: services.ClusterTypeECS, SecurityGroups: []string{"office"}}
+	assert.NoError(t, validateSecurityGroups(state))
+	state.ClusterType = services.ClusterTypeBMS
+	assert.Error(t, validateSecurityGroups(state))
+
+	state.SecurityGroupIDs = []string{"office-id"}
+	state.SecurityGroupID = "managed-id"
+	body, err := nodePoolCreateOpts{
+		Spec: nodePoolSpec{CustomSecurityGroups: nodeSecurityGroups(state)},
+	}.ToNodePoolCreateMap()
+	require.NoError(t, err)
+	spec := body["spec"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"office-id", "managed-id"}, spec["customSecurityGroups"])
+	assert.Equal(t, []string{"office-id"}, state.SecurityGroupIDs, "state is not changed")
+}
+
+func TestSetupSecurityGroups(t *testing.T) {
+	state := &clusterState{ClusterName: "test", SecurityGroupRules: []securityGroupRule{
+		{Protocol: "tcp", PortMin: 22, PortMax: 22, CIDR: "192.0.2.0/24"},
+		{Protocol: "udp", PortMin: 53, PortMax: 53, CIDR: "10.0.0.0/8"},
+	}}
+	groupsJSON := fmt.Sprintf(`{"security_groups": [
+		{"id": "other-id", "name": "test-nodes", "description": "created by user"},
+		{"id": "sg-id", "name": "test-nodes", "description": %q}
+	]}`, securityGroupDescription(state))
+	var created []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2.0/security-groups":
+			_, _ = fmt.Fprint(w, groupsJSON)
+		case r.Method == http.MethodPost && r.URL.Path == "/v2.0/security-groups":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprint(w, `{"security_group": {"id": "new-id", "name": "test-nodes"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2.0/security-group-rules":
+			assert.Equal(t, "ingress", r.URL.Query().Get("direction"))
+			_, _ = fmt.Fprint(w, `{"security_group_rules": [{"id": "rule-id", "direction": "ingress",
+				"protocol": "tcp", "port_range_min": 22, "port_range_max": 22, "remote_ip_prefix": "192.0.2.0/24"}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/v2.0/security-group-rules":
+			var body map[string]map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			created = append(created, body["security_group_rule"])
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprint(w, `{"security_group_rule": {"id": "new-rule-id"}}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+
+	require.NoError(t, setupSecurityGroups(client, state))
+	assert.Equal(t, "sg-id", state.SecurityGroupID, "only group created by the driver is adopted")
+	assert.True(t, state.ManagedResources.SecurityGroup)
+	require.Len(t, created, 1, "missing rules are created in adopted group")
+	assert.Equal(t, "sg-id", created[0]["security_group_id"])
+	assert.Equal(t, "udp", created[0]["protocol"])
+
+	groupsJSON = `{"security_groups": [{"id": "other-id", "name": "test-nodes", "description": "created by user"}]}`
+	state.SecurityGroupID = ""
+	state.ManagedResources.SecurityGroup = false
+	created = nil
+	require.NoError(t, setupSecurityGroups(client, state))
+	assert.Equal(t, "new-id", state.SecurityGroupID)
+	assert.True(t, state.ManagedResources.SecurityGroup)
+	assert.Len(t, created, 1)
+}
+
+func TestDeleteSecurityGroup(t *testing.T) {
+	deleted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/v2.0/security-groups/sg-id":
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && r.URL.Path == "/v2.0/security-groups/used-id":
+			w.WriteHeader(http.StatusConflict)
+		case r.URL.Path == "/v2.0/security-groups/sg-id" && !deleted:
+			_, _ = fmt.Fprint(w, `{"security_group": {"id": "sg-id"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := fakeNetworkClient(server.URL)
+	state := &clusterState{}
+
+	require.NoError(t, deleteSecurityGroup(context.Background(), client, state, "sg-id"))
+	assert.True(t, deleted)
+	assert.NoError(t, deleteSecurityGroup(context.Background(), client, state, "missing-id"), "missing group is not an error")
+	assert.Error(t, deleteSecurityGroup(context.Background(), client, state, "used-id"))
+}
@@ -0,0 +1,161 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// Timeouts are limits of driver operation phases and settings of polling and retries, all durations are in seconds
+type Timeouts struct {
+	// Network is the timeout of VPC, subnet, NAT gateway and security group setup and cleanup
+	Network int
+	// Cluster is the timeout of cluster creation or deletion
+	Cluster int
+	// Nodes is the timeout of node creation, scaling or deletion
+	Nodes int
+	// PostCheck is the timeout of cluster post-check including service account token retries
+	PostCheck int
+	// PollInterval is the interval between checks of CCE cluster and node status
+	PollInterval int
+	// Retries is the number of retries of failed service account token generation
+	Retries int
+	// RetryInterval is the delay before the first retry, it's doubled after each retry up to MaxRetryInterval
+	RetryInterval    int
+	MaxRetryInterval int
+}
+
+// NoRetries disables retries. It's used instead of 0 retries, as 0 is a value of not set option
+const NoRetries = -1
+
+// DefaultTimeouts returns timeouts used when neither driver options nor environment variables set them
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Network:          10 * 60,
+		Cluster:          30 * 60,
+		Nodes:            60 * 60,
+		PostCheck:        15 * 60,
+		PollInterval:     30,
+		Retries:          5,
+		RetryInterval:    30,
+		MaxRetryInterval: 5 * 60,
+	}
+}
+
+// timeoutFlags returns driver options of timeouts. Options have no defaults, otherwise Rancher saves them
+// in the cluster spec and driver defaults set by environment variables are never used
+func timeoutFlags() map[string]*types.Flag {
+	return map[string]*types.Flag{
+		"network-timeout": {
+			Type:  types.IntType,
+			Usage: "Timeout of VPC, subnet, NAT gateway and security group setup and cleanup, seconds",
+		},
+		"cluster-timeout": {
+			Type:  types.IntType,
+			Usage: "Timeout of cluster creation and deletion, seconds",
+		},
+		"nodes-timeout": {
+			Type:  types.IntType,
+			Usage: "Timeout of nodes creation, scaling and deletion, seconds",
+		},
+		"post-check-timeout": {
+			Type:  types.IntType,
+			Usage: "Timeout of cluster post-check, seconds",
+		},
+		"poll-interval": {
+			Type:  types.IntType,
+			Usage: "Interval between checks of cluster and nodes status, seconds",
+		},
+		"retries": {
+			Type:  types.IntType,
+			Usage: "Number of retries of failed service account token generation, 0 disables retries",
+		},
+		"retry-interval": {
+			Type:  types.IntType,
+			Usage: "Delay before the first retry, seconds. The delay is doubled after each retry up to max-retry-interval",
+		},
+		"max-retry-interval": {
+			Type:  types.IntType,
+			Usage: "Maximum delay between retries, seconds",
+		},
+	}
+}
+
+// timeoutsFromOpts reads timeouts from driver options, not set values are 0
+func timeoutsFromOpts(opts *types.DriverOptions) Timeouts {
+	_, _, intOpt, _ := getters(opts)
+	timeouts := Timeouts{
+		Network:          int(intOpt("network-timeout", "networkTimeout")),
+		Cluster:          int(intOpt("cluster-timeout", "clusterTimeout")),
+		Nodes:            int(intOpt("nodes-timeout", "nodesTimeout")),
+		PostCheck:        int(intOpt("post-check-timeout", "postCheckTimeout")),
+		PollInterval:     int(intOpt("poll-interval", "pollInterval")),
+		Retries:          int(intOpt("retries")),
+		RetryInterval:    int(intOpt("retry-interval", "retryInterval")),
+		MaxRetryInterval: int(intOpt("max-retry-interval", "maxRetryInterval")),
+	}
+	if _, ok := opts.IntOptions["retries"]; ok && timeouts.Retries <= 0 {
+		timeouts.Retries = NoRetries
+	}
+	return timeouts
+}
+
+// withDefaults returns timeouts with not set values replaced by defaults
+func (t Timeouts) withDefaults(defaults Timeouts) Timeouts {
+	orDefault := func(value, def int) int {
+		if value > 0 {
+			return value
+		}
+		return def
+	}
+	retries := t.Retries
+	if retries == 0 {
+		retries = defaults.Retries
+	}
+	return Timeouts{
+		Network:          orDefault(t.Network, defaults.Network),
+		Cluster:          orDefault(t.Cluster, defaults.Cluster),
+		Nodes:            orDefault(t.Nodes, defaults.Nodes),
+		PostCheck:        orDefault(t.PostCheck, defaults.PostCheck),
+		PollInterval:     orDefault(t.PollInterval, defaults.PollInterval),
+		Retries:          retries,
+		RetryInterval:    orDefault(t.RetryInterval, defaults.RetryInterval),
+		MaxRetryInterval: orDefault(t.MaxRetryInterval, defaults.MaxRetryInterval),
+	}
+}
+
+// resolveTimeouts sets effective timeouts of the operation. Only timeouts set in cluster options are persisted,
+// so changed driver defaults apply to existing clusters
+func (d *CCEDriver) resolveTimeouts(state *clusterState) {
+	state.Timeouts = state.TimeoutOptions.withDefaults(d.timeouts)
+	if state.Timeouts.Retries < 0 {
+		state.Timeouts.Retries = 0
+	}
+}
+
+// retryDelay returns delay before the retry with the given number starting from 0
+func (t Timeouts) retryDelay(retry int) time.Duration {
+	delay := t.RetryInterval
+	for i := 0; i < retry && delay < t.MaxRetryInterval; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxRetryInterval {
+		delay = t.MaxRetryInterval
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// runPhase runs `fn` with context limited by the phase timeout. Exceeded phase timeout is reported with the
+// phase name, so it's not confused with cancellation of the whole operation
+func runPhase(ctx context.Context, phase string, timeout int, fn func(context.Context) error) error {
+	phaseCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	err := fn(phaseCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s phase exceeded timeout of %d seconds: %w", phase, timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package opentelekomcloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTimeoutsFromOpts(t *testing.T) {
+	opts := &types.DriverOptions{IntOptions: map[string]int64{
+		"nodes-timeout": 7200,
+		"pollInterval":  10,
+	}}
+	timeouts := timeoutsFromOpts(opts)
+	assert.Equal(t, Timeouts{Nodes: 7200, PollInterval: 10}, timeouts)
+
+	defaults := DefaultTimeouts()
+	resolved := timeouts.withDefaults(defaults)
+	assert.Equal(t, 7200, resolved.Nodes)
+	assert.Equal(t, 10, resolved.PollInterval)
+	assert.Equal(t, defaults.Cluster, resolved.Cluster)
+	assert.Equal(t, defaults.Retries, resolved.Retries)
+
+	opts.IntOptions["retries"] = 0
+	timeouts = timeoutsFromOpts(opts)
+	assert.Equal(t, NoRetries, timeouts.Retries, "explicit 0 retries differs from not set option")
+	merged := Timeouts{}.withDefaults(timeouts)
+	assert.Equal(t, NoRetries, merged.Retries, "0 retries is kept when options are merged on update")
+
+	driver := NewDriverWithTimeouts(defaults).(*CCEDriver)
+	state := &clusterState{TimeoutOptions: merged}
+	driver.resolveTimeouts(state)
+	assert.Equal(t, 0, state.Timeouts.Retries)
+
+	driver = NewDriverWithTimeouts(Timeouts{Retries: NoRetries}).(*CCEDriver)
+	state = &clusterState{}
+	driver.resolveTimeouts(state)
+	assert.Equal(t, 0, state.Timeouts.Retries, "retries can be disabled by driver defaults")
+}
+
+func TestTimeoutFlags(t *testing.T) {
+	for name, flag := range timeoutFlags() {
+		assert.Nil(t, flag.Default, name)
+	}
+}
+
+func TestResolveTimeouts(t *testing.T) {
+	driver := NewDriverWithTimeouts(Timeouts{Cluster: 3600}).(*CCEDriver)
+	state := &clusterState{TimeoutOptions: Timeouts{Nodes: 7200}}
+	driver.resolveTimeouts(state)
+	assert.Equal(t, 7200, state.Timeouts.Nodes)
+	assert.Equal(t, 3600, state.Timeouts.Cluster)
+	assert.Equal(t, DefaultTimeouts().Network, state.Timeouts.Network)
+
+	info, err := stateToInfo(state, &types.ClusterInfo{})
+	require.NoError(t, err)
+	saved, err := infoToState(info)
+	require.NoError(t, err)
+	assert.Equal(t, Timeouts{Nodes: 7200}, saved.TimeoutOptions, "only timeouts set in options are saved")
+	assert.Equal(t, Timeouts{}, saved.Timeouts)
+}
+
+func TestRetryDelay(t *testing.T) {
+	timeouts := Timeouts{RetryInterval: 10, MaxRetryInterval: 50}
+	var delays []time.Duration
+	for retry := 0; retry < 5; retry++ {
+		delays = append(delays, timeouts.retryDelay(retry))
+	}
+	assert.Equal(t, []time.Duration{
+		10 * time.Second, 20 * time.Second, 40 * time.Second, 50 * time.Second, 50 * time.Second,
+	}, delays)
+}
+
+func TestRunPhase(t *testing.T) {
+	err := runPhase(context.Background(), "nodes", 0, func(ctx context.Context) error {
+		return waitFor(ctx, 60, 60, func() (bool, error) {
+			return false, nil
+		})
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "nodes phase exceeded timeout of 0 seconds")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = runPhase(ctx, "nodes", 60, func(ctx context.Context) error {
+		return checkContext(ctx)
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotContains(t, err.Error(), "phase exceeded timeout")
+}
+
+func TestRetryServiceAccountToken(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("create", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, assert.AnError
+	})
+	timeouts := Timeouts{Retries: 2, RetryInterval: 60, MaxRetryInterval: 60}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := retryServiceAccountToken(ctx, clientSet, timeouts)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "retry delay is interrupted by context")
+
+	timeouts.Retries = 0
+	_, err = retryServiceAccountToken(context.Background(), clientSet, timeouts)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, assert.AnError)
+}
@@ -0,0 +1,102 @@
+package opentelekomcloud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+)
+
+const (
+	minRootVolumeSize = 40
+	maxRootVolumeSize = 1024
+	minDataVolumeSize = 100
+	maxDataVolumeSize = 32768
+
+	// metadata keys of encrypted EVS volumes
+	volumeEncryptedKey = "__system__encrypted"
+	volumeKMSKeyIDKey  = "__system__cmkid"
+)
+
+var volumeTypes = []string{"SATA", "SAS", "SSD", "GPSSD", "ESSD"}
+
+// encryptVolume makes volume encrypted with KMS key, volume is not changed if `kmsKeyID` is empty
+func encryptVolume(volume *nodes.VolumeSpec, kmsKeyID string) {
+	if kmsKeyID == "" {
+		return
+	}
+	volume.Metadata = map[string]interface{}{
+		volumeEncryptedKey: "1",
+		volumeKMSKeyIDKey:  kmsKeyID,
+	}
+}
+
+// parseDataVolumes parses `data-volumes` option values in `size=<size>,type=<type>,kms-key-id=<id>` format.
+// Missing size and type are taken from `defaults`, single `defaults` volume is returned if no values are provided.
+func parseDataVolumes(values []string, defaults nodes.VolumeSpec) ([]nodes.VolumeSpec, error) {
+	if len(values) == 0 {
+		return []nodes.VolumeSpec{defaults}, nil
+	}
+	volumes := make([]nodes.VolumeSpec, len(values))
+	for i, value := range values {
+		volume := nodes.VolumeSpec{Size: defaults.Size, VolumeType: defaults.VolumeType}
+		for _, field := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid data volume parameter: %s", field)
+			}
+			key, val := kv[0], kv[1]
+			switch key {
+			case "size":
+				size, err := strconv.Atoi(val)
+				if err != nil {
+					return nil, fmt.Errorf("invalid data volume size %s: %s", val, err)
+				}
+				volume.Size = size
+			case "type":
+				volume.VolumeType = val
+			case "kms-key-id":
+				if val == "" {
+					return nil, fmt.Errorf("empty data volume kms-key-id")
+				}
+				encryptVolume(&volume, val)
+			default:
+				return nil, fmt.Errorf("unknown data volume parameter: %s", key)
+			}
+		}
+		volumes[i] = volume
+	}
+	return volumes, nil
+}
+
+func validateVolume(volume nodes.VolumeSpec, kind string, minSize, maxSize int) error {
+	if volume.Size < minSize || volume.Size > maxSize {
+		return fmt.Errorf("%s volume size should be between %d and %d GB, got %d", kind, minSize, maxSize, volume.Size)
+	}
+	for _, volumeType := range volumeTypes {
+		if volume.VolumeType == volumeType {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported %s volume type %s, should be one of %s",
+		kind, volume.VolumeType, strings.Join(volumeTypes, ", "))
+}
+
+// validateVolumes checks sizes and types of node volumes in all node pools
+func validateVolumes(state *clusterState) error {
+	for _, pool := range state.NodePools {
+		if err := validateVolume(pool.RootVolume, "root", minRootVolumeSize, maxRootVolumeSize); err != nil {
+			return fmt.Errorf("invalid node pool %s: %s", pool.Name, err)
+		}
+		if len(pool.DataVolumes) == 0 {
+			return fmt.Errorf("invalid node pool %s: at least one data volume is required", pool.Name)
+		}
+		for _, volume := range pool.DataVolumes {
+			if err := validateVolume(volume, "data", minDataVolumeSize, maxDataVolumeSize); err != nil {
+				return fmt.Errorf("invalid node pool %s: %s", pool.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package opentelekomcloud
+
+import (
+	"testing"
+
+	"github.com/opentelekomcloud/gophertelekomcloud/openstack/cce/v3/nodes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDataVolumes(t *testing.T) {
+	defaults := nodes.VolumeSpec{Size: 100, VolumeType: "SATA"}
+
+	volumes, err := parseDataVolumes(nil, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, []nodes.VolumeSpec{defaults}, volumes)
+
+	volumes, err = parseDataVolumes([]string{"size=200", "type=GPSSD, kms-key-id=key-id"}, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, []nodes.VolumeSpec{
+		{Size: 200, VolumeType: "SATA"},
+		{Size: 100, VolumeType: "GPSSD", Metadata: map[string]interface{}{
+			volumeEncryptedKey: "1",
+			volumeKMSKeyIDKey:  "key-id",
+		}},
+	}, volumes)
+
+	for _, value := range []string{"size=big", "iops=1000", "kms-key-id=", "size"} {
+		_, err := parseDataVolumes([]string{value}, defaults)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestValidateVolumes(t *testing.T) {
+	pool := nodePool{
+		Name:        "pool-1",
+		RootVolume:  nodes.VolumeSpec{Size: 40, VolumeType: "SAS"},
+		DataVolumes: []nodes.VolumeSpec{{Size: 100, VolumeType: "ESSD"}},
+	}
+	assert.NoError(t, validateVolumes(&clusterState{NodePools: []nodePool{pool}}))
+
+	invalid := []func(p *nodePool){
+		func(p *nodePool) { p.RootVolume.Size = 20 },
+		func(p *nodePool) { p.RootVolume.VolumeType = "HDD" },
+		func(p *nodePool) { p.DataVolumes = nil },
+		func(p *nodePool) { p.DataVolumes = []nodes.VolumeSpec{{Size: 50000, VolumeType: "SSD"}} },
+		func(p *nodePool) { p.DataVolumes = []nodes.VolumeSpec{{Size: 100, VolumeType: "NVME"}} },
+	}
+	for i, change := range invalid {
+		p := pool
+		change(&p)
+		assert.Error(t, validateVolumes(&clusterState{NodePools: []nodePool{p}}), "case %d", i)
+	}
+}